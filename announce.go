@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	KindMetadata       = 0
+	KindRelayList      = 10002
+	KindHandlerInfo    = 31990
+	handlerIdentifier  = "notification-daemon"
+	announceRetryDelay = time.Minute
+	announceAttempts   = 10
+)
+
+// Identity is what the daemon tells about itself in its announcements.
+type Identity struct {
+	Name    string
+	About   string
+	Picture string
+
+	// Relays go into the kind 10002 relay list.
+	Relays []string
+}
+
+func loadIdentity(strfryHost string) Identity {
+	return Identity{
+		Name:    getEnv("DAEMON_NAME", "Nostr push notifications"),
+		About:   getEnv("DAEMON_ABOUT", "Sends push notifications for the filters in your kind 10395 subscription."),
+		Picture: getEnv("DAEMON_PICTURE", ""),
+		Relays:  getEnvList("DAEMON_RELAYS", []string{strfryHost}),
+	}
+}
+
+// handlerInfo is the content of our NIP-89 handler announcement. Besides
+// the profile fields it tells clients what this daemon supports.
+type handlerInfo struct {
+	Name     string       `json:"name"`
+	About    string       `json:"about"`
+	Picture  string       `json:"picture,omitempty"`
+	Version  int          `json:"version"` // newest 10395 content version
+	Features []string     `json:"features"`
+	Limits   statusLimits `json:"limits"`
+}
+
+// features lists the optional parts of the protocol this daemon speaks, as
+// configured.
+func features() []string {
+	res := []string{"devices", "expiration", "quietHours", "digest", "minPow", "search", "rule", "test"}
+	if statusReplies != nil {
+		res = append(res, "status")
+	}
+	if tokenVerification != nil {
+		res = append(res, "verify")
+	}
+	if len(operatorPubkeys) > 0 {
+		res = append(res, "broadcast")
+	}
+	return res
+}
+
+func signedEvent(key *KeyMaterial, kind int, tags nostr.Tags, content string) (nostr.Event, error) {
+	event := nostr.Event{
+		PubKey:    key.publicKey,
+		CreatedAt: nostr.Now(),
+		Kind:      kind,
+		Tags:      tags,
+		Content:   content,
+	}
+	if err := key.signer.Sign(&event); err != nil {
+		return event, fmt.Errorf("failed to sign kind %d: %v", kind, err)
+	}
+	return event, nil
+}
+
+// announcements builds our profile, the NIP-89 handler announcement for
+// subscriptions and our relay list.
+func announcements(id Identity) ([]nostr.Event, error) {
+	profile, err := json.Marshal(map[string]string{
+		"name":    id.Name,
+		"about":   id.About,
+		"picture": id.Picture,
+	})
+	if err != nil {
+		return nil, err
+	}
+	info, err := json.Marshal(handlerInfo{
+		Name:     id.Name,
+		About:    id.About,
+		Picture:  id.Picture,
+		Version:  subscriptionVersion,
+		Features: features(),
+		Limits:   currentLimits(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	handlerTags := nostr.Tags{
+		{"d", handlerIdentifier},
+		{"k", fmt.Sprint(KindAppData)},
+		{"k", fmt.Sprint(KindDeviceAppData)},
+	}
+	var relayTags nostr.Tags
+	for _, url := range id.Relays {
+		relayTags = append(relayTags, nostr.Tag{"r", url})
+	}
+
+	var events []nostr.Event
+	for _, e := range []struct {
+		kind    int
+		tags    nostr.Tags
+		content string
+	}{
+		{KindMetadata, nostr.Tags{}, string(profile)},
+		{KindHandlerInfo, handlerTags, string(info)},
+		{KindRelayList, relayTags, ""},
+	} {
+		event, err := signedEvent(keys, e.kind, e.tags, e.content)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// rotationNotices returns a rotationNotice for each previous key.
+func rotationNotices() ([]nostr.Event, error) {
+	var events []nostr.Event
+	for _, prev := range keys.previous {
+		notice, err := rotationNotice(prev)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, notice)
+	}
+	return events, nil
+}
+
+// rotationNotice replaces the handler announcement of a previous key with
+// one pointing to the current key, signed by the previous key so clients
+// that only know it can trust it.
+func rotationNotice(prev *KeyMaterial) (nostr.Event, error) {
+	content, err := json.Marshal(map[string]string{"rotatedTo": keys.publicKey})
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	return signedEvent(prev, KindHandlerInfo, nostr.Tags{
+		{"d", handlerIdentifier},
+		{"p", keys.publicKey},
+	}, string(content))
+}
+
+// announceKeys publishes our identity if withIdentity is set, and the
+// rotation notices of previous keys in any case: clients that only know a
+// previous key would otherwise never learn the current one.
+func announceKeys(withIdentity bool, id Identity, relays []string, timeout time.Duration) {
+	var events []nostr.Event
+	if withIdentity {
+		var err error
+		if events, err = announcements(id); err != nil {
+			log.Printf("❌ Failed to create announcements: %v", err)
+			return
+		}
+	}
+	notices, err := rotationNotices()
+	if err != nil {
+		log.Printf("❌ Failed to create rotation notices: %v", err)
+		return
+	}
+	events = append(events, notices...)
+	if len(events) > 0 {
+		go announce(events, relays, timeout)
+	}
+}
+
+// announce publishes events to every relay, retrying relays that fail, e.g.
+// because they are still starting up. Run it in the background.
+func announce(events []nostr.Event, relays []string, timeout time.Duration) {
+	pending := relays
+	for attempt := 1; len(pending) > 0; attempt++ {
+		var failed []string
+		for _, url := range pending {
+			for _, event := range events {
+				if err := publishToRelay(url, event, timeout); err != nil {
+					log.Printf("❌ Failed to announce kind %d on %s: %v", event.Kind, url, err)
+					failed = append(failed, url)
+					break
+				}
+			}
+		}
+		if len(failed) < len(pending) {
+			log.Printf("📢 Announced ourselves as %s on %d relays", keys.publicKey, len(pending)-len(failed))
+		}
+		pending = failed
+		if len(pending) > 0 {
+			if attempt == announceAttempts {
+				log.Printf("❌ Giving up announcing on %d relays", len(pending))
+				return
+			}
+			time.Sleep(announceRetryDelay)
+		}
+	}
+}
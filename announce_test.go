@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRotationNotices(t *testing.T) {
+	defer func(k *KeyMaterial) { keys = k }(keys)
+
+	tests := []struct {
+		name     string
+		previous int
+	}{
+		{"no previous keys", 0},
+		{"one previous key", 1},
+		{"two previous keys", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var previous []string
+			for range tt.previous {
+				previous = append(previous, nostr.GeneratePrivateKey())
+			}
+			setupKeys(nostr.GeneratePrivateKey(), previous)
+
+			notices, err := rotationNotices()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(notices) != tt.previous {
+				t.Fatalf("got %d notices, want %d", len(notices), tt.previous)
+			}
+			for i, notice := range notices {
+				if notice.PubKey != keys.previous[i].publicKey {
+					t.Errorf("notice %d signed by %s, want previous key %s", i, notice.PubKey, keys.previous[i].publicKey)
+				}
+				if ok, _ := notice.CheckSignature(); !ok {
+					t.Errorf("notice %d has an invalid signature", i)
+				}
+				if p := notice.Tags.GetFirst([]string{"p"}); p == nil || (*p)[1] != keys.publicKey {
+					t.Errorf("notice %d points to %v, want %s", i, p, keys.publicKey)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/9ssi7/exponent"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindBroadcast is an announcement from an operator to all subscribers, or
+// to a segment of them. The kind is not standardized.
+const KindBroadcast = 1395
+
+// operatorPubkeys may send broadcasts. Broadcasts are ignored without any.
+var operatorPubkeys []string
+
+// Broadcast is the content of a KindBroadcast. Area and Locale narrow down
+// who gets it, both have to match if given.
+type Broadcast struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+
+	// Area is a plus code prefix like "9F4M", matched against the `#l`
+	// values of the subscribers' filters.
+	Area string `json:"area,omitempty"`
+
+	// Locale like "de" or "pt-BR", matched against the subscriber setting.
+	Locale string `json:"locale,omitempty"`
+}
+
+func isOperator(pubkey string) bool {
+	return slices.Contains(operatorPubkeys, pubkey)
+}
+
+// targets reports whether the broadcast is meant for a device of pubkey.
+func (b Broadcast) targets(reg *Registry, pubkey, device string) bool {
+	if b.Locale != "" && !localeMatches(reg.sm.Get(pubkey, device).Locale, b.Locale) {
+		return false
+	}
+	if b.Area != "" && !watchesArea(reg.fm.filtersByDevice[pubkey][device], b.Area) {
+		return false
+	}
+	return true
+}
+
+// localeMatches is true for "de-DE" and "de" when asked for "de".
+func localeMatches(locale, want string) bool {
+	locale = strings.ToLower(locale)
+	want = strings.ToLower(want)
+	return locale == want || strings.HasPrefix(locale, want+"-")
+}
+
+// watchesArea is true if a filter covers a part of area, or area lies in a
+// filter's area.
+func watchesArea(filters []SubscriptionFilter, area string) bool {
+	area = strings.TrimRight(strings.ToUpper(area), "0+")
+	for _, f := range filters {
+		for _, code := range f.Tags["l"] {
+			code = strings.TrimRight(strings.ToUpper(code), "0+")
+			if code == "" {
+				continue
+			}
+			if strings.HasPrefix(code, area) || strings.HasPrefix(area, code) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func broadcastMessage(b Broadcast, event nostr.Event) exponent.Message {
+	title := b.Title
+	if title == "" {
+		title = "Announcement"
+	}
+	return exponent.Message{
+		Title:    title,
+		Body:     truncateRunes(b.Body, 178),
+		Priority: exponent.DefaultPriority,
+		Data: exponent.Data{
+			"type":    "broadcast",
+			"eventId": event.ID,
+		},
+	}
+}
+
+// handleBroadcast sends an operator's announcement to every token of the
+// targeted subscribers. Broadcasts are meant to be read as they are, so they
+// are never folded into a summary: a token over its rate limit gets the
+// broadcast once its limit allows. It reports false for events that are not
+// from an operator, those are handled like any other event.
+func handleBroadcast(reg *Registry, event nostr.Event) bool {
+	if !isOperator(event.PubKey) {
+		return false
+	}
+	if ok, err := event.CheckSignature(); !ok {
+		log.Printf("⛔ Broadcast %s has an invalid signature: %v", event.ID, err)
+		return true
+	}
+	if maxEventAge > 0 && time.Since(event.CreatedAt.Time()) > maxEventAge {
+		log.Printf("⌛ Broadcast %s is older than %s, not sending", event.ID, maxEventAge)
+		return true
+	}
+
+	var b Broadcast
+	if err := json.Unmarshal([]byte(event.Content), &b); err != nil || b.Body == "" {
+		log.Printf("❌ Failed to parse broadcast %s: %v", event.ID, err)
+		return true
+	}
+
+	tokens, subscribers := broadcastTokens(reg, b)
+	log.Printf("📣 Broadcast %s from operator %s to %d subscribers, %d tokens", event.ID, event.PubKey, subscribers, len(tokens))
+	msg := broadcastMessage(b, event)
+	if allowed := limiter.AllowBroadcast(event.ID, tokens, msg); len(allowed) > 0 {
+		sendPush(allowed, msg)
+	}
+	return true
+}
+
+// broadcastTokens returns the active tokens of the devices b targets, each
+// once even if several pubkeys registered it, and the number of pubkeys.
+func broadcastTokens(reg *Registry, b Broadcast) ([]Pushtoken, int) {
+	var tokens []Pushtoken
+	seen := make(map[Pushtoken]bool)
+	subscribers := 0
+	for pubkey, devices := range reg.pm.pushkeysByDevice {
+		targeted := false
+		for device, registered := range devices {
+			if !b.targets(reg, pubkey, device) {
+				continue
+			}
+			targeted = true
+			for _, token := range activeTokens(pubkey, registered) {
+				if !seen[token] {
+					seen[token] = true
+					tokens = append(tokens, token)
+				}
+			}
+		}
+		if targeted {
+			subscribers++
+		}
+	}
+	return tokens, subscribers
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestBroadcastTokens(t *testing.T) {
+	reg := NewRegistry()
+	register := func(pubkey, device, locale, area string, tokens ...Pushtoken) {
+		reg.sm.UpdateSettings(nostr.Event{
+			Kind:    KindDeviceAppData,
+			PubKey:  pubkey,
+			Tags:    nostr.Tags{{"d", device}},
+			Content: `{"locale":"` + locale + `"}`,
+		})
+		if reg.fm.filtersByDevice[pubkey] == nil {
+			reg.fm.filtersByDevice[pubkey] = make(FilterMap)
+		}
+		reg.fm.filtersByDevice[pubkey][device] = []SubscriptionFilter{{Filter: nostr.Filter{
+			Kinds: []int{1},
+			Tags:  nostr.TagMap{"l": []string{area}},
+		}}}
+		if reg.pm.pushkeysByDevice[pubkey] == nil {
+			reg.pm.pushkeysByDevice[pubkey] = make(map[string][]Pushtoken)
+		}
+		reg.pm.pushkeysByDevice[pubkey][device] = tokens
+		reg.fm.merge(pubkey)
+		reg.pm.merge(pubkey)
+	}
+	register("alice", "phone", "de-DE", "9F4M", "alice-phone", "shared")
+	register("alice", "tablet", "en", "8FVC", "alice-tablet")
+	register("bob", "phone", "de", "9F4M", "bob-phone", "shared")
+
+	tests := []struct {
+		name        string
+		broadcast   Broadcast
+		tokens      []Pushtoken
+		subscribers int
+	}{
+		{"everyone, shared token once", Broadcast{}, []Pushtoken{"alice-phone", "alice-tablet", "bob-phone", "shared"}, 2},
+		{"locale per device", Broadcast{Locale: "de"}, []Pushtoken{"alice-phone", "bob-phone", "shared"}, 2},
+		{"area per device", Broadcast{Area: "8FVC"}, []Pushtoken{"alice-tablet"}, 1},
+		{"nobody", Broadcast{Locale: "fr"}, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, subscribers := broadcastTokens(reg, tt.broadcast)
+			slices.Sort(tokens)
+			if !slices.Equal(tokens, tt.tokens) || subscribers != tt.subscribers {
+				t.Errorf("broadcastTokens = %v, %d, want %v, %d", tokens, subscribers, tt.tokens, tt.subscribers)
+			}
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip46"
+)
+
+// bunkerReconnectDelay is how long the local stand-in bunker waits before
+// reconnecting to its relay.
+const bunkerReconnectDelay = 5 * time.Second
+
+// bunkerSigner delegates signing and nip04 to a NIP-46 remote signer, so the
+// daemon's private key doesn't have to be on this host. Requests and answers
+// are kind 24133 events, encrypted between our session key and the bunker.
+type bunkerSigner struct {
+	remote    string // pubkey of the bunker
+	publicKey string // the daemon's pubkey, held by the bunker
+	clientKey string // our session key
+	clientPub string
+	shared    []byte // between clientKey and remote
+	relays    []string
+	timeout   time.Duration
+
+	// decryptTimeout bounds nip04_decrypt, which runs on the ingest loop
+	// and holds up every event behind it while waiting for the bunker.
+	decryptTimeout time.Duration
+
+	connMu sync.Mutex
+	conns  map[string]*nostr.Relay
+
+	pendingMu sync.Mutex
+	pending   map[string]chan nip46.Response
+
+	// NIP-46 has no way to hand out a shared secret, so what we cache are
+	// decrypted subscriptions. A subscription seen again, at startup or
+	// from another relay, costs no round trip.
+	decrypted *lruCache[string]
+}
+
+// parseBunkerURL splits bunker://<pubkey>?relay=wss://...&secret=...
+func parseBunkerURL(bunkerURL string) (remote string, relays []string, secret string, err error) {
+	u, err := url.Parse(bunkerURL)
+	if err != nil {
+		return "", nil, "", err
+	}
+	if u.Scheme != "bunker" {
+		return "", nil, "", fmt.Errorf("not a bunker:// url")
+	}
+	remote = u.Host
+	if !nostr.IsValidPublicKeyHex(remote) {
+		return "", nil, "", fmt.Errorf("invalid bunker pubkey %q", remote)
+	}
+	relays = u.Query()["relay"]
+	if len(relays) == 0 {
+		return "", nil, "", fmt.Errorf("bunker url has no relay")
+	}
+	return remote, relays, u.Query().Get("secret"), nil
+}
+
+// connectBunker connects to the bunker and asks it for the daemon's pubkey.
+// Without a clientKey a new session key is generated on every start.
+func connectBunker(bunkerURL, clientKey string, timeout time.Duration) (*bunkerSigner, error) {
+	remote, relays, secret, err := parseBunkerURL(bunkerURL)
+	if err != nil {
+		return nil, err
+	}
+	if clientKey == "" {
+		clientKey = nostr.GeneratePrivateKey()
+	}
+	clientPub, err := nostr.GetPublicKey(clientKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client key: %v", err)
+	}
+	shared, err := nip04.ComputeSharedSecret(remote, clientKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute shared secret with bunker: %v", err)
+	}
+
+	b := &bunkerSigner{
+		remote:         remote,
+		clientKey:      clientKey,
+		clientPub:      clientPub,
+		shared:         shared,
+		relays:         relays,
+		timeout:        timeout,
+		decryptTimeout: timeout,
+		conns:          make(map[string]*nostr.Relay),
+		pending:        make(map[string]chan nip46.Response),
+		decrypted:      newLRUCache[string](sharedSecretCacheSize),
+	}
+
+	// NIP-46: connect names the remote signer, then the optional secret
+	params := []string{remote}
+	if secret != "" {
+		params = append(params, secret)
+	}
+	// the first request can get lost while the bunker's relay connections
+	// are still coming up, so connect is tried twice
+	if _, err := b.request("connect", params...); err != nil {
+		log.Printf("⚠️ Bunker didn't answer connect, retrying: %v", err)
+		if _, err := b.request("connect", params...); err != nil {
+			return nil, fmt.Errorf("connect: %v", err)
+		}
+	}
+	b.publicKey, err = b.request("get_public_key")
+	if err != nil {
+		return nil, fmt.Errorf("get_public_key: %v", err)
+	}
+	if !nostr.IsValidPublicKeyHex(b.publicKey) {
+		return nil, fmt.Errorf("bunker returned invalid pubkey %q", b.publicKey)
+	}
+	return b, nil
+}
+
+func setupBunkerKeys(bunkerURL, clientKey string, timeout, decryptTimeout time.Duration, previousKeysEnv []string) {
+	b, err := connectBunker(bunkerURL, clientKey, timeout)
+	if err != nil {
+		log.Fatalf("Failed to connect to bunker: %v", err)
+	}
+	b.decryptTimeout = decryptTimeout
+	log.Printf("🔐 Using bunker %s for publickey: %s", b.remote, b.publicKey)
+
+	keys = &KeyMaterial{
+		publicKey: b.publicKey,
+		signer:    b,
+	}
+	keys.addPrevious(previousKeysEnv)
+}
+
+// relay returns a connection to url on which we listen for answers,
+// connecting if there is none.
+func (b *bunkerSigner) relay(url string) (*nostr.Relay, error) {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+
+	if r := b.conns[url]; r != nil && r.Context().Err() == nil {
+		return r, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	r, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	// a little slack for the bunker's clock
+	since := nostr.Timestamp(time.Now().Add(-time.Minute).Unix())
+	sub, err := r.Subscribe(r.Context(), nostr.Filters{{
+		Kinds: []int{nostr.KindNostrConnect},
+		Tags:  nostr.TagMap{"p": []string{b.clientPub}},
+		Since: &since,
+	}})
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	go b.listen(sub)
+
+	b.conns[url] = r
+	return r, nil
+}
+
+// listen hands the bunker's answers to the waiting requests.
+func (b *bunkerSigner) listen(sub *nostr.Subscription) {
+	for ev := range sub.Events {
+		if ev.PubKey != b.remote {
+			continue
+		}
+		if ok, _ := ev.CheckSignature(); !ok {
+			continue
+		}
+		plain, err := nip04.Decrypt(ev.Content, b.shared)
+		if err != nil {
+			log.Printf("❌ Failed to decrypt bunker answer %s: %v", ev.ID, err)
+			continue
+		}
+		var resp nip46.Response
+		if err := json.Unmarshal([]byte(plain), &resp); err != nil {
+			log.Printf("❌ Failed to parse bunker answer %s: %v", ev.ID, err)
+			continue
+		}
+
+		// the same answer may come in through several relays
+		b.pendingMu.Lock()
+		ch := b.pending[resp.ID]
+		delete(b.pending, resp.ID)
+		b.pendingMu.Unlock()
+		if ch != nil {
+			ch <- resp
+		}
+	}
+}
+
+// request sends a NIP-46 request through every bunker relay and waits for
+// the first answer.
+func (b *bunkerSigner) request(method string, params ...string) (string, error) {
+	return b.requestWithin(b.timeout, method, params...)
+}
+
+func (b *bunkerSigner) requestWithin(timeout time.Duration, method string, params ...string) (string, error) {
+	id, err := newNonce()
+	if err != nil {
+		return "", err
+	}
+	content, err := json.Marshal(nip46.Request{ID: id, Method: method, Params: params})
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := nip04.Encrypt(string(content), b.shared)
+	if err != nil {
+		return "", err
+	}
+	event := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      nostr.KindNostrConnect,
+		Tags:      nostr.Tags{{"p", b.remote}},
+		Content:   encrypted,
+	}
+	if err := event.Sign(b.clientKey); err != nil {
+		return "", err
+	}
+
+	answer := make(chan nip46.Response, 1)
+	b.pendingMu.Lock()
+	b.pending[id] = answer
+	b.pendingMu.Unlock()
+	defer func() {
+		b.pendingMu.Lock()
+		delete(b.pending, id)
+		b.pendingMu.Unlock()
+	}()
+
+	sent := 0
+	for _, url := range b.relays {
+		r, err := b.relay(url)
+		if err != nil {
+			log.Printf("❌ Failed to connect to bunker relay %s: %v", url, err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err = r.Publish(ctx, event)
+		cancel()
+		if err != nil {
+			log.Printf("❌ Failed to send %s to bunker via %s: %v", method, url, err)
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		return "", fmt.Errorf("no bunker relay reachable")
+	}
+
+	select {
+	case resp := <-answer:
+		if resp.Error != "" {
+			return "", fmt.Errorf("bunker refused %s: %s", method, resp.Error)
+		}
+		return resp.Result, nil
+	case <-time.After(timeout):
+		return "", fmt.Errorf("bunker did not answer %s within %s", method, timeout)
+	}
+}
+
+func (b *bunkerSigner) Sign(event *nostr.Event) error {
+	event.PubKey = b.publicKey
+	unsigned, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	result, err := b.request("sign_event", string(unsigned))
+	if err != nil {
+		return err
+	}
+	var signed nostr.Event
+	if err := json.Unmarshal([]byte(result), &signed); err != nil {
+		return fmt.Errorf("failed to parse signed event: %v", err)
+	}
+	if ok, _ := signed.CheckSignature(); !ok || signed.PubKey != b.publicKey {
+		return fmt.Errorf("bunker returned an event not signed by %s", b.publicKey)
+	}
+	*event = signed
+	return nil
+}
+
+func (b *bunkerSigner) Encrypt(plaintext, recipientPublicKey string) (string, error) {
+	return b.request("nip04_encrypt", recipientPublicKey, plaintext)
+}
+
+// Decrypt is a round trip to the bunker for every subscription not seen
+// before. It is called from the ingest loop, so the wait is bounded by
+// decryptTimeout; a subscription that times out is rejected and has to be
+// republished.
+func (b *bunkerSigner) Decrypt(ciphertext, senderPublicKey string) (string, error) {
+	key := senderPublicKey + " " + ciphertext
+	if plain, ok := b.decrypted.Get(key); ok {
+		return plain, nil
+	}
+	plain, err := b.requestWithin(b.decryptTimeout, "nip04_decrypt", senderPublicKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	b.decrypted.Put(key, plain)
+	return plain, nil
+}
+
+// localBunkerReadyTimeout is how long startup waits for the local bunker
+// to be subscribed on its relay.
+const localBunkerReadyTimeout = 30 * time.Second
+
+// serveLocalBunker runs a NIP-46 bunker for privateKey on relayURL in the
+// background and returns its bunker:// url once it listens for requests. It
+// stands in for a real bunker when testing, the key is still on this host.
+func serveLocalBunker(privateKey, relayURL string) string {
+	if privateKey == "" {
+		log.Fatal("PRIVATEKEY not found in env, the local bunker needs it. Stopping")
+	}
+	publicKey := derivePublickey(privateKey)
+
+	ready := make(chan struct{})
+	var once sync.Once
+	go func() {
+		signer := nip46.NewSigner(privateKey)
+		for {
+			err := runLocalBunker(&signer, publicKey, relayURL, func() { once.Do(func() { close(ready) }) })
+			if err != nil {
+				log.Printf("❌ Local bunker on %s: %v. Restarting in %s", relayURL, err, bunkerReconnectDelay)
+			}
+			time.Sleep(bunkerReconnectDelay)
+		}
+	}()
+
+	select {
+	case <-ready:
+	case <-time.After(localBunkerReadyTimeout):
+		log.Fatalf("Local bunker didn't get subscribed on %s within %s. Stopping", relayURL, localBunkerReadyTimeout)
+	}
+	log.Printf("🔐 Serving a local bunker for %s on %s", publicKey, relayURL)
+	return fmt.Sprintf("bunker://%s?relay=%s", publicKey, url.QueryEscape(relayURL))
+}
+
+// runLocalBunker answers requests until the connection drops. It calls
+// ready once the relay confirmed the subscription with an EOSE, requests
+// published from then on are seen.
+func runLocalBunker(signer *nip46.Signer, publicKey, relayURL string, ready func()) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	relay, err := nostr.RelayConnect(ctx, relayURL)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+
+	since := nostr.Now()
+	sub, err := relay.Subscribe(relay.Context(), nostr.Filters{{
+		Kinds: []int{nostr.KindNostrConnect},
+		Tags:  nostr.TagMap{"p": []string{publicKey}},
+		Since: &since,
+	}})
+	if err != nil {
+		return err
+	}
+
+	eose := sub.EndOfStoredEvents
+	for {
+		var ev *nostr.Event
+		select {
+		case <-eose:
+			ready()
+			eose = nil
+			continue
+		case e, ok := <-sub.Events:
+			if !ok {
+				return fmt.Errorf("connection lost")
+			}
+			ev = e
+		}
+		req, _, answer, _, err := signer.HandleRequest(ev)
+		if err != nil {
+			log.Printf("❌ Local bunker failed to handle %s: %v", req.Method, err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = relay.Publish(ctx, answer)
+		cancel()
+		if err != nil {
+			log.Printf("❌ Local bunker failed to answer %s: %v", req.Method, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseBunkerURL(t *testing.T) {
+	const pubkey = "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+	tests := []struct {
+		name    string
+		url     string
+		relays  []string
+		secret  string
+		wantErr bool
+	}{
+		{"relay and secret", "bunker://" + pubkey + "?relay=wss%3A%2F%2Frelay.example.com&secret=s3cret", []string{"wss://relay.example.com"}, "s3cret", false},
+		{"several relays", "bunker://" + pubkey + "?relay=wss://a.example&relay=wss://b.example", []string{"wss://a.example", "wss://b.example"}, "", false},
+		{"no relay", "bunker://" + pubkey + "?secret=s3cret", nil, "", true},
+		{"wrong scheme", "nostrconnect://" + pubkey + "?relay=wss://a.example", nil, "", true},
+		{"bad pubkey", "bunker://npub1xyz?relay=wss://a.example", nil, "", true},
+		{"not a url", "bunker://%zz", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote, relays, secret, err := parseBunkerURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBunkerURL error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if remote != pubkey || !slices.Equal(relays, tt.relays) || secret != tt.secret {
+				t.Errorf("parseBunkerURL = %s, %v, %q, want %s, %v, %q", remote, relays, secret, pubkey, tt.relays, tt.secret)
+			}
+		})
+	}
+}
+
+func TestLocalBunkerIsReadyWhenServed(t *testing.T) {
+	relay := newFakeRelay(t, 0)
+	privateKey := nostr.GeneratePrivateKey()
+
+	// requests are ephemeral, one sent before the bunker subscribed would
+	// be lost and connect would time out
+	b, err := connectBunker(serveLocalBunker(privateKey, relay.URL), "", 5*time.Second)
+	if err != nil {
+		t.Fatalf("connectBunker: %v", err)
+	}
+	if want := derivePublickey(privateKey); b.publicKey != want {
+		t.Errorf("publicKey = %s, want %s", b.publicKey, want)
+	}
+}
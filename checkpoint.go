@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const checkpointFile = "checkpoint.json"
+
+// Checkpoint records how far the daemon got, so events published while it
+// was down can be fetched again on the next start.
+type Checkpoint struct {
+	// CreatedAt is the highest created_at of any processed event.
+	CreatedAt nostr.Timestamp `json:"createdAt"`
+
+	// The last message taken from RabbitMQ. Delivery tags only count within
+	// one channel, so they are kept for diagnosis, not for resuming.
+	ReceivedAt  int64  `json:"receivedAt,omitempty"`
+	DeliveryTag uint64 `json:"deliveryTag,omitempty"`
+
+	SavedAt nostr.Timestamp `json:"savedAt"`
+}
+
+type checkpointTracker struct {
+	mu    sync.Mutex
+	cp    Checkpoint
+	dirty bool
+}
+
+var checkpoint = &checkpointTracker{}
+
+func loadCheckpoint() (Checkpoint, bool) {
+	var cp Checkpoint
+	found, err := loadJSON(checkpointFile, &cp)
+	if err != nil {
+		log.Printf("❌ Failed to load checkpoint, starting without one: %v", err)
+		return cp, false
+	}
+	checkpoint.mu.Lock()
+	checkpoint.cp = cp
+	checkpoint.mu.Unlock()
+	return cp, found
+}
+
+// Observe moves the checkpoint forward. Timestamps from the future are not
+// trusted, otherwise one bogus event could make us skip a real gap.
+func (t *checkpointTracker) Observe(createdAt nostr.Timestamp) {
+	if createdAt > nostr.Now()+60 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if createdAt > t.cp.CreatedAt {
+		t.cp.CreatedAt = createdAt
+		t.dirty = true
+	}
+}
+
+func (t *checkpointTracker) ObserveDelivery(receivedAt int64, deliveryTag uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cp.ReceivedAt = receivedAt
+	t.cp.DeliveryTag = deliveryTag
+	t.dirty = true
+}
+
+func (t *checkpointTracker) Save() {
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return
+	}
+	t.cp.SavedAt = nostr.Now()
+	cp := t.cp
+	t.dirty = false
+	t.mu.Unlock()
+
+	if err := saveJSON(checkpointFile, cp); err != nil {
+		log.Printf("❌ Failed to save checkpoint: %v", err)
+	}
+}
+
+// backfillSince asks the startup relays for everything the active filters
+// match since the checkpoint and runs it through the normal match path,
+// oldest first. Events too old to notify about are dropped there.
+func backfillSince(since nostr.Timestamp, cfg StartupConfig, reg *Registry) {
+	filters := reg.fm.GetAllFilters()
+	if len(filters) == 0 {
+		log.Printf("⏪ No filters, nothing to backfill")
+		return
+	}
+
+	until := nostr.Now()
+	var query nostr.Filters
+	for _, f := range filters {
+		f = f.Clone()
+		f.Limit = 0
+		f.Search = ""
+		if f.Since == nil || *f.Since < since {
+			s := since
+			f.Since = &s
+		}
+		if f.Until == nil || *f.Until > until {
+			u := until
+			f.Until = &u
+		}
+		query = append(query, f)
+	}
+
+	seen := make(map[string]bool)
+	var events []nostr.Event
+	for _, url := range cfg.Relays {
+		res, err := queryRelay(url, query, cfg.Timeout)
+		if err != nil {
+			log.Printf("❌ Backfill from %s failed after %d events: %v", url, len(res), err)
+		}
+		for _, ev := range res {
+			if !seen[ev.ID] {
+				seen[ev.ID] = true
+				events = append(events, ev)
+			}
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].CreatedAt < events[j].CreatedAt })
+	log.Printf("⏪ Backfilling %d events published since %s", len(events), since.Time().Format(time.RFC3339))
+
+	for _, ev := range events {
+		processEvent(reg, ev, "backfill")
+	}
+}
+
+// queryRelay collects stored events for filters until EOSE or timeout.
+func queryRelay(url string, filters nostr.Filters, timeout time.Duration) ([]nostr.Event, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer relay.Close()
+
+	sub, err := relay.Subscribe(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsub()
+
+	var events []nostr.Event
+	for {
+		select {
+		case ev, ok := <-sub.Events:
+			if !ok {
+				return events, nil
+			}
+			events = append(events, *ev)
+		case <-sub.EndOfStoredEvents:
+			return events, nil
+		case <-ctx.Done():
+			return events, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ===== Helpers for reading optional settings from the environment =====
+
+func getEnv(name string, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(name string, fallback int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("⚠️ Ignoring %s=%q, not a number. Using %d.", name, v, fallback)
+		return fallback
+	}
+	return n
+}
+
+// getEnvDuration accepts Go durations ("90s", "2h") and plain numbers,
+// which are read as seconds.
+func getEnvDuration(name string, fallback time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("⚠️ Ignoring %s=%q, not a duration. Using %s.", name, v, fallback)
+		return fallback
+	}
+	return d
+}
+
+func getEnvBool(name string, fallback bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("⚠️ Ignoring %s=%q, not a boolean. Using %t.", name, v, fallback)
+		return fallback
+	}
+	return b
+}
+
+// getEnvList splits a comma separated value, dropping empty entries.
+func getEnvList(name string, fallback []string) []string {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	var res []string
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			res = append(res, part)
+		}
+	}
+	return res
+}
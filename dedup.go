@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"sync"
+	"time"
+)
+
+const seenEventsFile = "seen-events.json"
+
+// seenEventStore remembers event ids for a while, so that RabbitMQ
+// redeliveries, backfill and copies from several relays notify only once.
+type seenEventStore struct {
+	mu     sync.Mutex
+	window time.Duration
+	seen   map[string]int64 // event id -> unix time first seen
+	dirty  bool
+}
+
+var seenEvents = newSeenEventStore(24 * time.Hour)
+
+func newSeenEventStore(window time.Duration) *seenEventStore {
+	return &seenEventStore{
+		window: window,
+		seen:   make(map[string]int64),
+	}
+}
+
+// loadSeenEvents replaces the store with the one persisted in dataDir.
+func loadSeenEvents(window time.Duration) {
+	store := newSeenEventStore(window)
+	if _, err := loadJSON(seenEventsFile, &store.seen); err != nil {
+		log.Printf("❌ Failed to load seen events, starting empty: %v", err)
+		store.seen = make(map[string]int64)
+	}
+	store.prune()
+	log.Printf("👀 Remembering %d events seen in the last %s", len(store.seen), window)
+	seenEvents = store
+}
+
+// FirstSeen marks id as seen and reports whether it was new.
+func (s *seenEventStore) FirstSeen(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.seen[id]; ok {
+		return false
+	}
+	s.seen[id] = time.Now().Unix()
+	s.dirty = true
+	return true
+}
+
+func (s *seenEventStore) prune() {
+	cutoff := time.Now().Add(-s.window).Unix()
+	for id, at := range s.seen {
+		if at < cutoff {
+			delete(s.seen, id)
+			s.dirty = true
+		}
+	}
+}
+
+// Save writes a copy of the store, so ingest isn't held up by the write.
+func (s *seenEventStore) Save() {
+	s.mu.Lock()
+	s.prune()
+	if !s.dirty {
+		s.mu.Unlock()
+		return
+	}
+	seen := maps.Clone(s.seen)
+	s.dirty = false
+	s.mu.Unlock()
+
+	if err := saveJSON(seenEventsFile, seen); err != nil {
+		log.Printf("❌ Failed to save seen events: %v", err)
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindDeviceAppData is the addressable variant of KindAppData. Each device
+// of a subscriber publishes its own, told apart by the `d` tag, so devices
+// don't replace each other's filters and tokens.
+const KindDeviceAppData = 30395
+
+func isAppData(kind int) bool {
+	return kind == KindAppData || kind == KindDeviceAppData
+}
+
+// deviceOf returns the device a subscription event is for, "" for a 10395.
+func deviceOf(event nostr.Event) string {
+	if event.Kind != KindDeviceAppData {
+		return ""
+	}
+	return event.Tags.GetD()
+}
+
+// subscriptionKey identifies a subscription the way relays replace them.
+func subscriptionKey(event nostr.Event) string {
+	return deviceKey(event.PubKey, deviceOf(event))
+}
+
+func deviceKey(pubkey, device string) string {
+	if device != "" {
+		return pubkey + ":" + device
+	}
+	return pubkey
+}
+
+// checkDeviceTag rejects a 30395 without a device id, it would be mistaken
+// for the pubkey's 10395.
+func checkDeviceTag(event nostr.Event) *Rejection {
+	if event.Kind == KindDeviceAppData && deviceOf(event) == "" {
+		return &Rejection{Field: "d", Reason: "a 30395 needs a non-empty d tag"}
+	}
+	return nil
+}
+
+// checkDeviceLimit rejects a subscription from one device too many.
+func checkDeviceLimit(fm *FilterManager, event nostr.Event) *Rejection {
+	limit := subscriptionLimits.MaxDevices
+	devices := fm.filtersByDevice[event.PubKey]
+	if _, known := devices[deviceOf(event)]; known || limit <= 0 || len(devices) < limit {
+		return nil
+	}
+	return &Rejection{Field: "d", Reason: fmt.Sprintf("more than %d devices", limit)}
+}
+
+func sortedDevices[T any](byDevice map[string]T) []string {
+	devices := make([]string, 0, len(byDevice))
+	for device := range byDevice {
+		devices = append(devices, device)
+	}
+	slices.Sort(devices)
+	return devices
+}
+
+// merge rebuilds the filters of a pubkey from all its devices.
+func (fm *FilterManager) merge(pubkey string) {
+	if len(fm.filtersByDevice[pubkey]) == 0 {
+		delete(fm.filtersByDevice, pubkey)
+		delete(fm.filtersByPubkey, pubkey)
+		return
+	}
+	var merged []SubscriptionFilter
+	for _, device := range sortedDevices(fm.filtersByDevice[pubkey]) {
+		merged = append(merged, fm.filtersByDevice[pubkey][device]...)
+	}
+	fm.filtersByPubkey[pubkey] = merged
+}
+
+// merge rebuilds the tokens of a pubkey from all its devices.
+func (pm *PushManager) merge(pubkey string) {
+	for _, token := range pm.pushkeysByPubkey[pubkey] {
+		delete(pm.pubkeysByToken[token], pubkey)
+		if len(pm.pubkeysByToken[token]) == 0 {
+			delete(pm.pubkeysByToken, token)
+		}
+	}
+
+	var merged []Pushtoken
+	for _, device := range sortedDevices(pm.pushkeysByDevice[pubkey]) {
+		for _, token := range pm.pushkeysByDevice[pubkey][device] {
+			if !slices.Contains(merged, token) {
+				merged = append(merged, token)
+			}
+		}
+	}
+	for _, token := range merged {
+		if pm.pubkeysByToken[token] == nil {
+			pm.pubkeysByToken[token] = make(map[string]bool)
+		}
+		pm.pubkeysByToken[token][pubkey] = true
+	}
+	if len(pm.pushkeysByDevice[pubkey]) == 0 {
+		delete(pm.pushkeysByDevice, pubkey)
+	}
+	if merged == nil {
+		delete(pm.pushkeysByPubkey, pubkey)
+		return
+	}
+	pm.pushkeysByPubkey[pubkey] = merged
+}
+
+// RemoveDevice forgets a device that no longer has filters, and the pubkey
+// with everything kept for it once its last device is gone.
+func (reg *Registry) RemoveDevice(pubkey, device string) {
+	delete(reg.fm.filtersByDevice[pubkey], device)
+	reg.fm.merge(pubkey)
+	delete(reg.pm.pushkeysByDevice[pubkey], device)
+	reg.pm.merge(pubkey)
+	delete(reg.subscriptions, deviceKey(pubkey, device))
+	delete(reg.rejections, deviceKey(pubkey, device))
+	reg.sm.Remove(pubkey, device)
+	if tokenVerification != nil {
+		tokenVerification.Retain(pubkey, reg.pm.pushkeysByPubkey[pubkey])
+	}
+
+	if _, ok := reg.fm.filtersByPubkey[pubkey]; ok {
+		return
+	}
+	log.Printf("👋 Forgetting pubkey %s", pubkey)
+	delete(reg.sm.settingsByDevice, pubkey)
+	delete(reg.mm.listsByPubkey, pubkey)
+	digests.Forget(pubkey)
+}
+
+// TokensFor returns the tokens of the devices whose filters matched, so a
+// device is only notified about its own subscription.
+func (pm *PushManager) TokensFor(pubkey string, matched []SubscriptionFilter) []Pushtoken {
+	var tokens []Pushtoken
+	var seen []string
+	for _, f := range matched {
+		if slices.Contains(seen, f.device) {
+			continue
+		}
+		seen = append(seen, f.device)
+		for _, token := range pm.pushkeysByDevice[pubkey][f.device] {
+			if !slices.Contains(tokens, token) {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
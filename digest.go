@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const digestsFile = "digests.json"
+
+// heldDigest collects the events a subscriber should get as one grouped
+// notification later instead of one push each.
+type heldDigest struct {
+	Pubkey    string      `json:"pubkey"`
+	Device    string      `json:"device,omitempty"`
+	Reason    string      `json:"reason"`
+	Tokens    []Pushtoken `json:"tokens"`
+	ReleaseAt int64       `json:"releaseAt"`
+	EventIDs  []string    `json:"eventIds"`
+	PlusCodes []string    `json:"plusCodes"`
+}
+
+// digestBuffer is persisted, so held events survive a restart.
+type digestBuffer struct {
+	mu      sync.Mutex
+	digests map[string]*heldDigest // pubkey[:device]/reason -> digest
+	dirty   bool
+}
+
+var digests = &digestBuffer{digests: make(map[string]*heldDigest)}
+
+func loadDigests() {
+	held := make(map[string]*heldDigest)
+	if _, err := loadJSON(digestsFile, &held); err != nil {
+		log.Printf("❌ Failed to load held digests, starting empty: %v", err)
+		return
+	}
+	digests.mu.Lock()
+	digests.digests = held
+	digests.mu.Unlock()
+	log.Printf("📬 Loaded %d held digests", len(held))
+}
+
+// Hold adds event to the subscriber's digest for reason. The release time is
+// only set when the digest is started, later events join it.
+func (b *digestBuffer) Hold(pubkey, device string, reason string, releaseAt time.Time, tokens []Pushtoken, event nostr.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := deviceKey(pubkey, device) + "/" + reason
+	d, ok := b.digests[key]
+	if !ok {
+		d = &heldDigest{Pubkey: pubkey, Device: device, Reason: reason, ReleaseAt: releaseAt.Unix()}
+		b.digests[key] = d
+	}
+	d.Tokens = tokens
+	d.EventIDs = append(d.EventIDs, event.ID)
+	d.PlusCodes = append(d.PlusCodes, plusCodeFromTags(event))
+	b.dirty = true
+
+	log.Printf("📬 Holding event %s for pubkey %s (%s) until %s, %d held",
+		event.ID, pubkey, reason, time.Unix(d.ReleaseAt, 0).Format(time.RFC3339), len(d.EventIDs))
+}
+
+// Forget drops the held digests of a subscriber that is gone.
+func (b *digestBuffer) Forget(pubkey string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for key, d := range b.digests {
+		if d.Pubkey == pubkey {
+			delete(b.digests, key)
+			b.dirty = true
+		}
+	}
+}
+
+func (b *digestBuffer) due(now time.Time) []*heldDigest {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var res []*heldDigest
+	for key, d := range b.digests {
+		if d.ReleaseAt <= now.Unix() {
+			res = append(res, d)
+			delete(b.digests, key)
+			b.dirty = true
+		}
+	}
+	return res
+}
+
+func (b *digestBuffer) Save() {
+	b.mu.Lock()
+	if !b.dirty {
+		b.mu.Unlock()
+		return
+	}
+	// Hold keeps appending to the digests, so they are copied, not just
+	// the map
+	held := make(map[string]heldDigest, len(b.digests))
+	for key, d := range b.digests {
+		c := *d
+		c.Tokens = slices.Clone(d.Tokens)
+		c.EventIDs = slices.Clone(d.EventIDs)
+		c.PlusCodes = slices.Clone(d.PlusCodes)
+		held[key] = c
+	}
+	b.dirty = false
+	b.mu.Unlock()
+
+	if err := saveJSON(digestsFile, held); err != nil {
+		log.Printf("❌ Failed to save held digests: %v", err)
+		b.mu.Lock()
+		b.dirty = true
+		b.mu.Unlock()
+	}
+}
+
+// releaseDigestsEvery sends held digests once they are due.
+func releaseDigestsEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, d := range digests.due(time.Now()) {
+			log.Printf("📬 Releasing %d held events (%s) to pubkey %s", len(d.EventIDs), d.Reason, d.Pubkey)
+			sendPush(d.Tokens, summaryMessage(d.Reason, d.EventIDs, d.PlusCodes))
+		}
+		digests.Save()
+	}
+}
+
+const (
+	DeliveryPush   = "push"
+	DeliveryDigest = "digest"
+)
+
+// DigestSchedule says when a filter with "delivery": "digest" is sent, in
+// the subscriber's timezone, e.g. {"every": "weekly", "weekday": "sunday", "at": "18:00"}.
+type DigestSchedule struct {
+	Every   string `json:"every"` // "daily" or "weekly"
+	At      string `json:"at,omitempty"`
+	Weekday string `json:"weekday,omitempty"`
+}
+
+var defaultDigestSchedule = DigestSchedule{Every: "daily", At: "08:00"}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+func (s DigestSchedule) validate() error {
+	if s.Every != "daily" && s.Every != "weekly" {
+		return fmt.Errorf("unknown digest schedule %q, expected daily or weekly", s.Every)
+	}
+	if _, err := parseClock(s.at()); err != nil {
+		return err
+	}
+	if _, ok := weekdays[s.weekday()]; s.Every == "weekly" && !ok {
+		return fmt.Errorf("unknown weekday %q", s.Weekday)
+	}
+	return nil
+}
+
+func (s DigestSchedule) at() string {
+	if s.At == "" {
+		return defaultDigestSchedule.At
+	}
+	return s.At
+}
+
+func (s DigestSchedule) weekday() string {
+	if s.Weekday == "" {
+		return "monday"
+	}
+	return strings.ToLower(s.Weekday)
+}
+
+func (s DigestSchedule) title() string {
+	if s.Every == "weekly" {
+		return "Your weekly digest"
+	}
+	return "Your daily digest"
+}
+
+// Next is the first scheduled time after now. Like quiet hours it works on
+// the wall clock, so an 08:00 digest stays at 08:00 on days the clocks change.
+func (s DigestSchedule) Next(now time.Time, loc *time.Location) time.Time {
+	d, _ := parseClock(s.at())
+	now = now.In(loc)
+	// at is the scheduled time on the day offset days from today
+	at := func(days int) time.Time {
+		return time.Date(now.Year(), now.Month(), now.Day()+days, int(d/time.Hour), int(d%time.Hour/time.Minute), 0, 0, loc)
+	}
+
+	if s.Every == "weekly" {
+		days := (int(weekdays[s.weekday()]) - int(now.Weekday()) + 7) % 7
+		if next := at(days); next.After(now) {
+			return next
+		}
+		return at(days + 7)
+	}
+
+	if next := at(0); next.After(now) {
+		return next
+	}
+	return at(1)
+}
+
+// holdForDigest queues event for the digest of the matched digest filter
+// that is due first.
+func holdForDigest(pubkey, device string, settings SubscriberSettings, matched []SubscriptionFilter, tokens []Pushtoken, event nostr.Event) {
+	now := time.Now()
+	var first DigestSchedule
+	var releaseAt time.Time
+	for _, f := range matched {
+		schedule := f.digestSchedule()
+		next := schedule.Next(now, settings.location())
+		if releaseAt.IsZero() || next.Before(releaseAt) {
+			first, releaseAt = schedule, next
+		}
+	}
+	digests.Hold(pubkey, device, first.title(), releaseAt, tokens, event)
+}
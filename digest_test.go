@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDigestScheduleNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, berlin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	daily := DigestSchedule{Every: "daily", At: "08:00"}
+
+	tests := []struct {
+		name     string
+		schedule DigestSchedule
+		now      time.Time
+		want     time.Time
+	}{
+		{"later today", daily, at("2026-06-10 07:00"), at("2026-06-10 08:00")},
+		{"at the time rolls over", daily, at("2026-06-10 08:00"), at("2026-06-11 08:00")},
+		{"past the time rolls over", daily, at("2026-06-10 21:00"), at("2026-06-11 08:00")},
+		{"rolls over into the next year", DigestSchedule{Every: "daily", At: "23:30"}, at("2026-12-31 23:45"), at("2027-01-01 23:30")},
+		{"now in another zone", daily, at("2026-06-10 07:00").UTC(), at("2026-06-10 08:00")},
+		{"default time", DigestSchedule{Every: "daily"}, at("2026-06-10 07:00"), at("2026-06-10 08:00")},
+		{"clocks go forward today", daily, at("2026-03-29 00:30"), at("2026-03-29 08:00")},
+		{"clocks go forward tonight", daily, at("2026-03-28 09:00"), at("2026-03-29 08:00")},
+		{"clocks go back today", daily, at("2026-10-25 00:30"), at("2026-10-25 08:00")},
+		{"clocks go back tonight", daily, at("2026-10-24 09:00"), at("2026-10-25 08:00")},
+		{"weekly later this week", DigestSchedule{Every: "weekly", Weekday: "sunday", At: "18:00"}, at("2026-06-10 12:00"), at("2026-06-14 18:00")},
+		{"weekly later today", DigestSchedule{Every: "weekly", Weekday: "Wednesday", At: "18:00"}, at("2026-06-10 12:00"), at("2026-06-10 18:00")},
+		{"weekly rolls over to next week", DigestSchedule{Every: "weekly", Weekday: "wednesday", At: "08:00"}, at("2026-06-10 12:00"), at("2026-06-17 08:00")},
+		{"weekly default weekday", DigestSchedule{Every: "weekly"}, at("2026-06-10 12:00"), at("2026-06-15 08:00")},
+		{"weekly across clocks going forward", DigestSchedule{Every: "weekly", Weekday: "monday"}, at("2026-03-28 12:00"), at("2026-03-30 08:00")},
+		{"weekly across clocks going back", DigestSchedule{Every: "weekly", Weekday: "sunday", At: "18:00"}, at("2026-10-18 19:00"), at("2026-10-25 18:00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.Next(tt.now, berlin); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.now, got.In(berlin), tt.want)
+			}
+		})
+	}
+}
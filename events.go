@@ -0,0 +1,258 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// maxEventAge suppresses notifications about events older than this, which
+// mostly matters for backfilled events. Zero disables the check.
+var maxEventAge time.Duration
+
+// strfryMessage is the wrapper strfry puts around events it hands to us,
+// see https://github.com/hoytech/strfry/blob/master/docs/plugins.md
+type strfryMessage struct {
+	Event      nostr.Event `json:"event"`
+	Type       string      `json:"type"`
+	ReceivedAt int64       `json:"receivedAt"`
+	SourceInfo string      `json:"sourceInfo"`
+}
+
+// processEvent runs a single event from any ingest source through the
+// subscription update or the matching path. Sources learn about changed
+// subscriptions through the callback they gave to watch.
+func processEvent(reg *Registry, event nostr.Event, source string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if !seenEvents.FirstSeen(event.ID) {
+		log.Printf("👀 Already processed event %s, skipping copy from %s", event.ID, source)
+		return
+	}
+	defer checkpoint.Observe(event.CreatedAt)
+
+	if isAppData(event.Kind) {
+		if handleAppData(reg, event, true) {
+			reg.changed()
+		}
+		return
+	}
+	if event.Kind == KindBroadcast && handleBroadcast(reg, event) {
+		return
+	}
+	if event.Kind == KindMuteList {
+		_, isSubscriber := reg.fm.filtersByPubkey[event.PubKey]
+		reg.mm.UpdateMuteList(event, isSubscriber)
+	}
+
+	// checking the NIP-26 signature once, not for every subscriber
+	handleEvent(reg, event, delegatorOf(event), source)
+}
+
+// watch runs sync now and after every change to the subscriptions, so an
+// ingest source keeps listening for what the filters need. The first error
+// is returned, later ones are only logged.
+func (reg *Registry) watch(sync func() error) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.onChange = func() {
+		if err := sync(); err != nil {
+			log.Printf("❌ Failed to follow changed subscriptions: %v", err)
+		}
+	}
+	return sync()
+}
+
+// changed is called with mu held.
+func (reg *Registry) changed() {
+	if reg.onChange != nil {
+		reg.onChange()
+	}
+}
+
+// handleAppData applies a subscription update. Subscriptions loaded at startup
+// are not live, they were confirmed to the client when they first came in.
+func handleAppData(reg *Registry, event nostr.Event, live bool) bool {
+	log.Printf("📥 Received new appData message from pubkey: %s", event.PubKey)
+
+	if !isEncryptedAndIsForMe(event) {
+		return false
+	}
+	// relays may hand us an older copy after the newer one
+	if info, ok := reg.subscriptions[subscriptionKey(event)]; ok && event.CreatedAt < info.updatedAt {
+		log.Printf("⏪ Ignoring subscription %s from %s, the one we have is from %s",
+			event.ID, event.CreatedAt.Time().Format(time.RFC3339), info.updatedAt.Time().Format(time.RFC3339))
+		return false
+	}
+
+	ourKey := addressedKey(event)
+	if ourKey != keys {
+		log.Printf("🔑 Subscription %s is encrypted to our previous key %s", event.ID, ourKey.publicKey)
+	}
+	decryptedContent, err := decryptContent(event.Content, event.PubKey, ourKey)
+	if err != nil {
+		log.Printf("Decrytption failed for message: %s", event.ID)
+		log.Printf("err: %v", err)
+		reg.rejections[subscriptionKey(event)] = []Rejection{{Field: "content", Reason: "could not decrypt"}}
+		if live && statusReplies != nil {
+			statusReplies.Reply(reg, event)
+		}
+		return false
+	}
+
+	event.Content = decryptedContent
+
+	key := subscriptionKey(event)
+	device := deviceOf(event)
+	if r := checkDeviceTag(event); r != nil {
+		log.Printf("🚫 Ignoring subscription %s: %s", event.ID, r.Reason)
+		reg.rejections[key] = []Rejection{*r}
+		if live && statusReplies != nil {
+			statusReplies.Reply(reg, event)
+		}
+		return false
+	}
+	// an expired subscription also ends the one it replaces
+	if r := checkExpiration(event); r != nil {
+		log.Printf("⌛ Subscription %s arrived expired, removing it", key)
+		reg.RemoveDevice(event.PubKey, device)
+		reg.rejections[key] = []Rejection{*r}
+		if live && statusReplies != nil {
+			statusReplies.Reply(reg, event)
+		}
+		return true
+	}
+
+	r := checkVersion(event)
+	if r == nil {
+		r = checkDeviceLimit(reg.fm, event)
+	}
+	if r != nil {
+		log.Printf("🚫 Ignoring subscription %s: %s", key, r.Reason)
+		reg.rejections[key] = []Rejection{*r}
+		if live && statusReplies != nil {
+			statusReplies.Reply(reg, event)
+		}
+		return false
+	}
+
+	var rejections []Rejection
+	log.Printf("🔄🔍 Updating filters")
+	rejections = append(rejections, reg.fm.UpdateFilters(event)...)
+
+	log.Printf("🔄📱 Updating pushkeys")
+	tokensBefore := reg.pm.pushkeysByDevice[event.PubKey][device]
+	rejections = append(rejections, reg.pm.UpdatePushkeys(event)...)
+
+	var verified []Pushtoken
+	if tokenVerification != nil {
+		var r []Rejection
+		verified, r = applyVerifications(event)
+		rejections = append(rejections, r...)
+		for _, token := range verified {
+			reg.evictUnverified(token, event.PubKey)
+		}
+	}
+
+	reg.trackSubscription(event)
+
+	// a device unsubscribes by publishing its 30395 without filters
+	if device != "" && len(reg.fm.filtersByDevice[event.PubKey][device]) == 0 {
+		log.Printf("📴 Removing device %q of pubkey %s", device, event.PubKey)
+		reg.RemoveDevice(event.PubKey, device)
+	}
+	if tokenVerification != nil {
+		tokenVerification.Retain(event.PubKey, reg.pm.pushkeysByPubkey[event.PubKey])
+	}
+
+	rejections = append(rejections, reg.sm.UpdateSettings(event)...)
+	reg.rejections[key] = rejections
+	if len(rejections) > 0 {
+		log.Printf("🚫 Subscription %s had %d problems", key, len(rejections))
+	}
+
+	if live {
+		if statusReplies != nil {
+			statusReplies.Reply(reg, event)
+		}
+		if tokenVerification != nil {
+			sendVerificationPushes(reg, event)
+			sendConfirmations(event, verified)
+		}
+		sendTestPushes(reg, event, tokensBefore)
+	}
+
+	reg.pm.printPushtoken()
+	log.Printf("----------------------------------")
+
+	return true
+}
+
+// handleEvent matches a regular event. delegator is its NIP-26 delegator,
+// "" if it has none.
+func handleEvent(reg *Registry, event nostr.Event, delegator string, source string) {
+	log.Printf("📋 Parsed Nostr Event:\n"+
+		"  ID: %s\n"+
+		"  Kind: %d\n"+
+		"  Created: %v\n"+
+		"  Content: %s\n"+
+		"  PubKey: %s\n"+
+		"  Source: %s",
+		event.ID,
+		event.Kind,
+		event.CreatedAt,
+		event.Content,
+		event.PubKey,
+		source)
+
+	if maxEventAge > 0 && time.Since(event.CreatedAt.Time()) > maxEventAge {
+		log.Printf("⌛ Event %s is older than %s, not notifying", event.ID, maxEventAge)
+		return
+	}
+
+	matches := 0
+	// several filters of one subscriber may match, one push is enough
+	var pubkeys []string
+	matched := make(map[string][]SubscriptionFilter)
+	for _, pair := range reg.fm.GetAllFiltersPubKeyPairs() {
+		if isOwnEvent(event, delegator, pair.pubkey, reg.sm.Get(pair.pubkey, pair.filter.device)) {
+			log.Printf("🪞 Event %s is from subscriber %s themselves, skipping", event.ID, pair.pubkey)
+			continue
+		}
+		log.Printf("🔍 Checking against filter: %+v", pair.filter)
+		if pair.filter.matches(&event) {
+			log.Printf("✅ Filter matched event kind %d filter: %v. pubkey: %s, event: %v", event.Kind, pair.filter, pair.pubkey, event)
+			matches++
+			if matched[pair.pubkey] == nil {
+				pubkeys = append(pubkeys, pair.pubkey)
+			}
+			matched[pair.pubkey] = append(matched[pair.pubkey], pair.filter)
+		} else {
+			log.Printf("❌ Filter did not match event kind %d", event.Kind)
+		}
+	}
+
+	unmuted := pubkeys[:0]
+	for _, pubkey := range pubkeys {
+		if reg.mm.IsMuted(pubkey, event) {
+			log.Printf("🔇 Pubkey %s muted event %s", pubkey, event.ID)
+			continue
+		}
+		unmuted = append(unmuted, pubkey)
+	}
+	pubkeys = unmuted
+
+	allowed := fanoutQuota.Take(event.PubKey, len(pubkeys))
+	for _, pubkey := range pubkeys[:allowed] {
+		handleMatchedEvent(reg, pubkey, matched[pubkey], event)
+	}
+
+	if matches == 0 {
+		log.Printf("❌ No filter matches for event kind %d", event.Kind)
+	} else {
+		log.Printf("✨ Event matched %d filters", matches)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// subscriptionInfo is what we keep about a subscription event itself.
+type subscriptionInfo struct {
+	pubkey    string
+	device    string
+	updatedAt nostr.Timestamp
+	expiresAt nostr.Timestamp // NIP-40, zero if the event doesn't expire
+}
+
+// subscriptionTTL evicts subscriptions that haven't been republished for this
+// long, e.g. from uninstalled apps. Zero disables it.
+var subscriptionTTL time.Duration
+
+// sweepInterval is how often sweepEvery sweeps.
+var sweepInterval = time.Minute
+
+// expirationOf returns the NIP-40 expiration of event, zero if it has none.
+func expirationOf(event nostr.Event) nostr.Timestamp {
+	tag := event.Tags.GetFirst([]string{"expiration", ""})
+	if tag == nil {
+		return 0
+	}
+	ts, err := strconv.ParseInt(tag.Value(), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return nostr.Timestamp(ts)
+}
+
+// checkExpiration rejects subscriptions that expired before they arrived.
+func checkExpiration(event nostr.Event) *Rejection {
+	if exp := expirationOf(event); exp != 0 && exp <= nostr.Now() {
+		return &Rejection{Field: "expiration", Reason: "subscription has expired"}
+	}
+	return nil
+}
+
+func (reg *Registry) trackSubscription(event nostr.Event) {
+	reg.subscriptions[subscriptionKey(event)] = subscriptionInfo{
+		pubkey:    event.PubKey,
+		device:    deviceOf(event),
+		updatedAt: event.CreatedAt,
+		expiresAt: expirationOf(event),
+	}
+}
+
+// sweepEvery evicts expired subscriptions in the background, so they end on
+// time even while no events come in.
+func (reg *Registry) sweepEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		reg.mu.Lock()
+		if reg.sweepExpired(time.Now()) {
+			reg.changed()
+		}
+		reg.mu.Unlock()
+	}
+}
+
+// sweepExpired evicts expired and inactive subscriptions and drops filters
+// whose `until` has passed. It reports whether anything changed.
+func (reg *Registry) sweepExpired(now time.Time) bool {
+	ts := nostr.Timestamp(now.Unix())
+	changed := false
+
+	for _, info := range reg.subscriptions {
+		switch {
+		case info.expiresAt != 0 && info.expiresAt <= ts:
+			log.Printf("⌛ Subscription of pubkey %s, device %q expired", info.pubkey, info.device)
+		case subscriptionTTL > 0 && now.Sub(info.updatedAt.Time()) > subscriptionTTL:
+			log.Printf("⌛ Subscription of pubkey %s, device %q inactive since %s", info.pubkey, info.device, info.updatedAt.Time().Format(time.RFC3339))
+		default:
+			continue
+		}
+		reg.RemoveDevice(info.pubkey, info.device)
+		changed = true
+	}
+
+	for pubkey, devices := range reg.fm.filtersByDevice {
+		for device, filters := range devices {
+			var active []SubscriptionFilter
+			for _, f := range filters {
+				if f.Until != nil && *f.Until < ts {
+					log.Printf("⌛ Filter %v of pubkey %s ended", f.Filter, pubkey)
+					continue
+				}
+				active = append(active, f)
+			}
+			if len(active) == len(filters) {
+				continue
+			}
+			changed = true
+			if len(active) == 0 {
+				reg.RemoveDevice(pubkey, device)
+				continue
+			}
+			devices[device] = active
+			reg.fm.merge(pubkey)
+		}
+	}
+
+	return changed
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+func TestSweepEveryFollowsChanges(t *testing.T) {
+	reg := NewRegistry()
+	reg.fm.filtersByDevice["alice"] = FilterMap{"": {{Filter: nostr.Filter{Kinds: []int{1}}}}}
+	reg.fm.merge("alice")
+	reg.subscriptions["alice"] = subscriptionInfo{pubkey: "alice", expiresAt: nostr.Now() - 1}
+
+	synced := make(chan int, 10)
+	reg.watch(func() error {
+		synced <- len(reg.fm.filtersByPubkey)
+		return nil
+	})
+	if n := <-synced; n != 1 {
+		t.Fatalf("initial sync saw %d pubkeys, want 1", n)
+	}
+
+	go reg.sweepEvery(10 * time.Millisecond)
+	select {
+	case n := <-synced:
+		if n != 0 {
+			t.Errorf("sync after sweep saw %d pubkeys, want 0", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expired subscription was not swept in the background")
+	}
+}
+
+func TestHandleAppDataIgnoresOlderSubscription(t *testing.T) {
+	defer func(k *KeyMaterial) { keys = k }(keys)
+	setupKeys(nostr.GeneratePrivateKey(), nil)
+
+	subscriber := nostr.GeneratePrivateKey()
+	subscriberPub, _ := nostr.GetPublicKey(subscriber)
+	shared, err := nip04.ComputeSharedSecret(keys.publicKey, subscriber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription := func(kind int, createdAt nostr.Timestamp) nostr.Event {
+		content, err := nip04.Encrypt(fmt.Sprintf(`{"filters":[{"filter":{"kinds":[%d]}}]}`, kind), shared)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nostr.Event{
+			Kind:      KindAppData,
+			PubKey:    subscriberPub,
+			CreatedAt: createdAt,
+			Tags:      nostr.Tags{{"p", keys.publicKey}},
+			Content:   content,
+		}
+	}
+
+	now := nostr.Now()
+	tests := []struct {
+		name      string
+		event     nostr.Event
+		wantKinds int
+	}{
+		{"first", subscription(1, now), 1},
+		{"older copy", subscription(7, now-60), 1},
+		{"newer", subscription(7, now+60), 7},
+	}
+	reg := NewRegistry()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handleAppData(reg, tt.event, false)
+			filters := reg.fm.filtersByPubkey[subscriberPub]
+			if len(filters) != 1 || filters[0].Kinds[0] != tt.wantKinds {
+				t.Errorf("filters = %v, want kind %d", filters, tt.wantKinds)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// fakeRelay is a minimal NIP-01 relay for tests. It answers REQs newest
+// first, at most maxLimit events per REQ like relays that cap `limit`,
+// and forwards new events to open subscriptions. Ephemeral events are not
+// stored.
+type fakeRelay struct {
+	URL      string
+	maxLimit int
+
+	mu     sync.Mutex
+	events []nostr.Event
+	subs   map[*fakeConn]map[string]nostr.Filters
+}
+
+type fakeConn struct {
+	mu   sync.Mutex
+	conn interface{ Write([]byte) (int, error) }
+}
+
+func (c *fakeConn) send(v ...any) {
+	b, _ := json.Marshal(v)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wsutil.WriteServerText(c.conn, b)
+}
+
+func newFakeRelay(t *testing.T, maxLimit int, events ...nostr.Event) *fakeRelay {
+	r := &fakeRelay{maxLimit: maxLimit, events: events, subs: make(map[*fakeConn]map[string]nostr.Filters)}
+	srv := httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(srv.Close)
+	r.URL = "ws" + strings.TrimPrefix(srv.URL, "http")
+	return r
+}
+
+func (r *fakeRelay) serve(w http.ResponseWriter, req *http.Request) {
+	conn, _, _, err := ws.UpgradeHTTP(req, w)
+	if err != nil {
+		return
+	}
+	c := &fakeConn{conn: conn}
+	defer func() {
+		r.mu.Lock()
+		delete(r.subs, c)
+		r.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		msg, _, err := wsutil.ReadClientData(conn)
+		if err != nil {
+			return
+		}
+		var arr []json.RawMessage
+		if json.Unmarshal(msg, &arr) != nil || len(arr) < 2 {
+			continue
+		}
+		var typ, id string
+		json.Unmarshal(arr[0], &typ)
+		switch typ {
+		case "REQ":
+			json.Unmarshal(arr[1], &id)
+			var filters nostr.Filters
+			for _, raw := range arr[2:] {
+				var f nostr.Filter
+				json.Unmarshal(raw, &f)
+				filters = append(filters, f)
+			}
+			for _, ev := range r.query(filters) {
+				c.send("EVENT", id, ev)
+			}
+			c.send("EOSE", id)
+			r.mu.Lock()
+			if r.subs[c] == nil {
+				r.subs[c] = make(map[string]nostr.Filters)
+			}
+			r.subs[c][id] = filters
+			r.mu.Unlock()
+		case "CLOSE":
+			json.Unmarshal(arr[1], &id)
+			r.mu.Lock()
+			delete(r.subs[c], id)
+			r.mu.Unlock()
+		case "EVENT":
+			var ev nostr.Event
+			json.Unmarshal(arr[1], &ev)
+			r.publish(ev)
+			c.send("OK", ev.ID, true, "")
+		}
+	}
+}
+
+func (r *fakeRelay) query(filters nostr.Filters) []nostr.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var res []nostr.Event
+	for _, f := range filters {
+		var matched []nostr.Event
+		for _, ev := range r.events {
+			if f.Matches(&ev) {
+				matched = append(matched, ev)
+			}
+		}
+		slices.SortFunc(matched, func(a, b nostr.Event) int { return int(b.CreatedAt - a.CreatedAt) })
+		limit := len(matched)
+		if f.Limit > 0 {
+			limit = min(limit, f.Limit)
+		}
+		if r.maxLimit > 0 {
+			limit = min(limit, r.maxLimit)
+		}
+		res = append(res, matched[:limit]...)
+	}
+	return res
+}
+
+func (r *fakeRelay) publish(ev nostr.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ev.Kind < 20000 || ev.Kind >= 30000 {
+		r.events = append(r.events, ev)
+	}
+	for c, subs := range r.subs {
+		for id, filters := range subs {
+			if filters.Match(&ev) {
+				go c.send("EVENT", id, ev)
+			}
+		}
+	}
+}
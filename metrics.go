@@ -0,0 +1,24 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+)
+
+// Counters are published with expvar at /debug/vars when METRICS_ADDR is set.
+var (
+	pushesSent       = expvar.NewInt("pushes_sent")
+	pushesFailed     = expvar.NewInt("pushes_failed")
+	testPushesSent   = expvar.NewInt("test_pushes_sent")
+	testPushesFailed = expvar.NewInt("test_pushes_failed")
+
+	tokenClaimConflicts = expvar.NewInt("token_claim_conflicts")
+)
+
+func serveMetrics(addr string) {
+	log.Printf("📈 Serving metrics on http://%s/debug/vars", addr)
+	if err := http.ListenAndServe(addr, nil); err != nil {
+		log.Printf("❌ Metrics server stopped: %v", err)
+	}
+}
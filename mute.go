@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	KindMuteList = 10000
+)
+
+// MuteList is the public part of a NIP-51 mute list. Private entries are
+// encrypted to the author and can't be read by us.
+type MuteList struct {
+	createdAt nostr.Timestamp
+	pubkeys   map[string]bool
+	hashtags  map[string]bool
+	threads   map[string]bool
+	words     []string
+}
+
+type MuteManager struct {
+	listsByPubkey map[string]MuteList
+}
+
+func NewMuteManager() *MuteManager {
+	return &MuteManager{
+		listsByPubkey: make(map[string]MuteList),
+	}
+}
+
+func parseMuteList(event nostr.Event) MuteList {
+	list := MuteList{
+		createdAt: event.CreatedAt,
+		pubkeys:   make(map[string]bool),
+		hashtags:  make(map[string]bool),
+		threads:   make(map[string]bool),
+	}
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || tag[1] == "" {
+			continue
+		}
+		switch tag[0] {
+		case "p":
+			list.pubkeys[tag[1]] = true
+		case "t":
+			list.hashtags[strings.ToLower(tag[1])] = true
+		case "e":
+			list.threads[tag[1]] = true
+		case "word":
+			// normalized like search terms, so they match whole words only
+			if word := normalizeText(tag[1]); strings.TrimSpace(word) != "" {
+				list.words = append(list.words, word)
+			}
+		}
+	}
+	return list
+}
+
+// UpdateMuteList keeps the newest mute list of subscribers; lists of anyone
+// else are of no use to us.
+func (mm *MuteManager) UpdateMuteList(event nostr.Event, isSubscriber bool) {
+	if event.Kind != KindMuteList || !isSubscriber {
+		return
+	}
+	if cur, ok := mm.listsByPubkey[event.PubKey]; ok && cur.createdAt > event.CreatedAt {
+		return
+	}
+
+	list := parseMuteList(event)
+	mm.listsByPubkey[event.PubKey] = list
+	log.Printf("🔇 Mute list of pubkey %s: %d pubkeys, %d hashtags, %d threads, %d words",
+		event.PubKey, len(list.pubkeys), len(list.hashtags), len(list.threads), len(list.words))
+}
+
+// IsMuted reports whether subscriber muted the author, a hashtag, a word or
+// the thread of event.
+func (mm *MuteManager) IsMuted(subscriber string, event nostr.Event) bool {
+	list, ok := mm.listsByPubkey[subscriber]
+	if !ok {
+		return false
+	}
+
+	if list.pubkeys[event.PubKey] || list.threads[event.ID] {
+		return true
+	}
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		if tag[0] == "t" && list.hashtags[strings.ToLower(tag[1])] {
+			return true
+		}
+		if tag[0] == "e" && list.threads[tag[1]] {
+			return true
+		}
+	}
+	if len(list.words) > 0 {
+		content := normalizeText(event.Content)
+		for _, word := range list.words {
+			if strings.Contains(content, word) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestIsMutedWords(t *testing.T) {
+	tests := []struct {
+		name    string
+		words   []string
+		content string
+		muted   bool
+	}{
+		{"whole word", []string{"ass"}, "what an ass", true},
+		{"case and punctuation", []string{"ass"}, "Ass!", true},
+		{"inside a word", []string{"ass"}, "pass the class", false},
+		{"diacritics", []string{"cafe"}, "Meet at the Café", true},
+		{"phrase", []string{"bad news"}, "this is Bad  News.", true},
+		{"phrase split", []string{"bad news"}, "bad weather, good news", false},
+		{"empty word ignored", []string{" ", "!"}, "anything", false},
+		{"no words", nil, "anything", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := nostr.Event{Kind: KindMuteList, PubKey: "sub"}
+			for _, w := range tt.words {
+				list.Tags = append(list.Tags, nostr.Tag{"word", w})
+			}
+			mm := NewMuteManager()
+			mm.UpdateMuteList(list, true)
+
+			event := nostr.Event{Kind: 1, PubKey: "author", Content: tt.content}
+			if got := mm.IsMuted("sub", event); got != tt.muted {
+				t.Errorf("IsMuted(%q) with words %q = %v, want %v", tt.content, tt.words, got, tt.muted)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// skipOwnEvents is the operator default for not notifying subscribers about
+// their own events; subscribers can opt back in with "notifyOwnEvents".
+var skipOwnEvents = true
+
+// isOwnEvent reports whether the subscriber wrote the event: with their
+// subscription key, one of the "otherKeys" they declared, or through a
+// NIP-26 delegation from one of those. delegator is delegatorOf(event),
+// which is too expensive to check for every subscriber.
+func isOwnEvent(event nostr.Event, delegator string, pubkey string, settings SubscriberSettings) bool {
+	if !skipOwnEvents || settings.NotifyOwnEvents {
+		return false
+	}
+
+	authors := []string{event.PubKey}
+	if delegator != "" {
+		authors = append(authors, delegator)
+	}
+
+	for _, author := range authors {
+		if author == pubkey {
+			return true
+		}
+		for _, key := range settings.OtherKeys {
+			if author == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// delegatorOf returns the delegator of a NIP-26 delegated event, or "" if
+// there is no valid delegation tag.
+// Tag format: ["delegation", <delegator pubkey>, <conditions>, <signature>]
+func delegatorOf(event nostr.Event) string {
+	tag := event.Tags.GetFirst([]string{"delegation", ""})
+	if tag == nil || len(*tag) < 4 {
+		return ""
+	}
+	delegator, conditions, sig := (*tag)[1], (*tag)[2], (*tag)[3]
+
+	if !delegationConditionsMet(event, conditions) {
+		return ""
+	}
+
+	pubkeyBytes, err := hex.DecodeString(delegator)
+	if err != nil {
+		return ""
+	}
+	pubkey, err := schnorr.ParsePubKey(pubkeyBytes)
+	if err != nil {
+		return ""
+	}
+	sigBytes, err := hex.DecodeString(sig)
+	if err != nil {
+		return ""
+	}
+	signature, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return ""
+	}
+
+	token := sha256.Sum256([]byte("nostr:delegation:" + event.PubKey + ":" + conditions))
+	if !signature.Verify(token[:], pubkey) {
+		return ""
+	}
+	return delegator
+}
+
+// delegationConditionsMet checks a query string like
+// "kind=1&created_at>1674834236&created_at<1677426236".
+func delegationConditionsMet(event nostr.Event, conditions string) bool {
+	for _, cond := range strings.Split(conditions, "&") {
+		switch {
+		case strings.HasPrefix(cond, "kind="):
+			kind, err := strconv.Atoi(strings.TrimPrefix(cond, "kind="))
+			if err != nil || kind != event.Kind {
+				return false
+			}
+		case strings.HasPrefix(cond, "created_at>"):
+			ts, err := strconv.ParseInt(strings.TrimPrefix(cond, "created_at>"), 10, 64)
+			if err != nil || int64(event.CreatedAt) <= ts {
+				return false
+			}
+		case strings.HasPrefix(cond, "created_at<"):
+			ts, err := strconv.ParseInt(strings.TrimPrefix(cond, "created_at<"), 10, 64)
+			if err != nil || int64(event.CreatedAt) >= ts {
+				return false
+			}
+		case cond == "":
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	// the alpine image comes without zoneinfo
+	_ "time/tzdata"
+)
+
+const (
+	QuietModeDigest = "digest" // hold pushes and send one digest when the window ends
+	QuietModeSilent = "silent" // deliver without sound or alert
+)
+
+// QuietHours is a daily window like {"start": "22:00", "end": "07:00"} in
+// the subscriber's timezone. Windows may span midnight.
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Mode  string `json:"mode,omitempty"` // QuietModeDigest (default) or QuietModeSilent
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (q QuietHours) validate() error {
+	if _, err := parseClock(q.Start); err != nil {
+		return err
+	}
+	if _, err := parseClock(q.End); err != nil {
+		return err
+	}
+	if q.Mode != "" && q.Mode != QuietModeDigest && q.Mode != QuietModeSilent {
+		return fmt.Errorf("unknown quiet hours mode %q", q.Mode)
+	}
+	return nil
+}
+
+func (q QuietHours) mode() string {
+	if q.Mode == "" {
+		return QuietModeDigest
+	}
+	return q.Mode
+}
+
+// EndsAt reports whether now is inside the window and, if so, when it ends.
+// It works on the wall clock, so a window keeps its times on days the clocks
+// change.
+func (q QuietHours) EndsAt(now time.Time, loc *time.Location) (time.Time, bool) {
+	start, err := parseClock(q.Start)
+	if err != nil {
+		return time.Time{}, false
+	}
+	end, err := parseClock(q.End)
+	if err != nil || start == end {
+		return time.Time{}, false
+	}
+
+	now = now.In(loc)
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute +
+		time.Duration(now.Second())*time.Second + time.Duration(now.Nanosecond())
+	// at is the time of day d on the day offset days from today
+	at := func(days int, d time.Duration) time.Time {
+		return time.Date(now.Year(), now.Month(), now.Day()+days, int(d/time.Hour), int(d%time.Hour/time.Minute), 0, 0, loc)
+	}
+
+	switch {
+	case start < end && clock >= start && clock < end:
+		return at(0, end), true
+	case start > end && clock >= start:
+		return at(1, end), true
+	case start > end && clock < end:
+		return at(0, end), true
+	}
+	return time.Time{}, false
+}
+
+// location falls back to UTC for unknown or missing timezones.
+func (s SubscriberSettings) location() *time.Location {
+	if s.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// quietDelivery decides how an event that matched the given filters of one
+// subscriber is delivered: "" for a normal push, or one of the quiet modes
+// with the time the digest is due. The loudest filter wins, so a filter
+// outside its quiet hours always gets a normal push.
+func quietDelivery(settings SubscriberSettings, matched []SubscriptionFilter, now time.Time) (string, time.Time) {
+	loc := settings.location()
+	mode := QuietModeDigest
+	var releaseAt time.Time
+
+	for _, f := range matched {
+		qh := f.QuietHours
+		if qh == nil {
+			qh = settings.QuietHours
+		}
+		if qh == nil {
+			return "", time.Time{}
+		}
+		end, active := qh.EndsAt(now, loc)
+		if !active {
+			return "", time.Time{}
+		}
+		if qh.mode() == QuietModeSilent {
+			mode = QuietModeSilent
+		}
+		if end.After(releaseAt) {
+			releaseAt = end
+		}
+	}
+	return mode, releaseAt
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHoursEndsAt(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, berlin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		name       string
+		start, end string
+		now        time.Time
+		wantActive bool
+		wantEnd    time.Time
+	}{
+		{"inside same day window", "09:00", "17:00", at("2024-06-10 12:00"), true, at("2024-06-10 17:00")},
+		{"before same day window", "09:00", "17:00", at("2024-06-10 08:59"), false, time.Time{}},
+		{"at window end", "09:00", "17:00", at("2024-06-10 17:00"), false, time.Time{}},
+		{"overnight before midnight", "22:00", "07:00", at("2024-06-10 23:30"), true, at("2024-06-11 07:00")},
+		{"overnight after midnight", "22:00", "07:00", at("2024-06-11 02:00"), true, at("2024-06-11 07:00")},
+		{"overnight outside", "22:00", "07:00", at("2024-06-10 12:00"), false, time.Time{}},
+		{"empty window", "07:00", "07:00", at("2024-06-10 07:00"), false, time.Time{}},
+		// clocks go forward at 02:00 on 2024-03-31 and back at 03:00 on 2024-10-27
+		{"spring forward", "00:00", "07:00", at("2024-03-31 01:00"), true, at("2024-03-31 07:00")},
+		{"spring forward after the jump", "00:00", "07:00", at("2024-03-31 04:00"), true, at("2024-03-31 07:00")},
+		{"fall back", "00:00", "07:00", at("2024-10-27 04:00"), true, at("2024-10-27 07:00")},
+		{"fall back outside", "00:00", "04:00", at("2024-10-27 04:30"), false, time.Time{}},
+		{"overnight into spring forward", "22:00", "07:00", at("2024-03-30 23:00"), true, at("2024-03-31 07:00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := QuietHours{Start: tt.start, End: tt.end}
+			end, active := q.EndsAt(tt.now, berlin)
+			if active != tt.wantActive {
+				t.Fatalf("active = %v, want %v", active, tt.wantActive)
+			}
+			if active && !end.Equal(tt.wantEnd) {
+				t.Errorf("ends at %s, want %s", end, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestQuietHoursValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       QuietHours
+		wantErr bool
+	}{
+		{"valid", QuietHours{Start: "22:00", End: "07:00"}, false},
+		{"valid silent", QuietHours{Start: "22:00", End: "07:00", Mode: QuietModeSilent}, false},
+		{"bad start", QuietHours{Start: "25:00", End: "07:00"}, true},
+		{"bad end", QuietHours{Start: "22:00", End: "7"}, true},
+		{"bad mode", QuietHours{Start: "22:00", End: "07:00", Mode: "loud"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.q.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQuietDelivery(t *testing.T) {
+	night := &QuietHours{Start: "22:00", End: "07:00"}
+	silent := &QuietHours{Start: "22:00", End: "07:00", Mode: QuietModeSilent}
+	now := time.Date(2024, 6, 10, 23, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		settings SubscriberSettings
+		matched  []SubscriptionFilter
+		want     string
+	}{
+		{"no quiet hours", SubscriberSettings{}, []SubscriptionFilter{{}}, ""},
+		{"subscriber quiet hours", SubscriberSettings{QuietHours: night}, []SubscriptionFilter{{}}, QuietModeDigest},
+		{"filter overrides to silent", SubscriberSettings{QuietHours: night}, []SubscriptionFilter{{FilterOptions: FilterOptions{QuietHours: silent}}}, QuietModeSilent},
+		{"loudest filter wins", SubscriberSettings{}, []SubscriptionFilter{{FilterOptions: FilterOptions{QuietHours: night}}, {}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := quietDelivery(tt.settings, tt.matched, now); got != tt.want {
+				t.Errorf("mode = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/9ssi7/exponent"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// RateLimit is a token bucket: PerMinute pushes refill the bucket, Burst is
+// its size. A PerMinute of zero means no limit.
+type RateLimit struct {
+	PerMinute float64 `json:"perMinute"`
+	Burst     int     `json:"burst"`
+}
+
+func (l RateLimit) unlimited() bool {
+	return l.PerMinute <= 0
+}
+
+func (l RateLimit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return max(1, l.PerMinute)
+}
+
+// stricter combines a subscriber's own limit with the operator's, so
+// subscribers can only lower their limit.
+func (l RateLimit) stricter(own *RateLimit) RateLimit {
+	if own == nil || own.unlimited() {
+		return l
+	}
+	if l.unlimited() {
+		return *own
+	}
+	res := l
+	res.PerMinute = min(l.PerMinute, own.PerMinute)
+	if own.Burst > 0 && float64(own.Burst) < l.capacity() {
+		res.Burst = own.Burst
+	}
+	return res
+}
+
+func (l RateLimit) String() string {
+	if l.unlimited() {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%g/min burst %g", l.PerMinute, l.capacity())
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  RateLimit // as last refilled with
+}
+
+func (b *tokenBucket) refill(limit RateLimit, now time.Time) {
+	b.tokens = min(limit.capacity(), b.tokens+now.Sub(b.last).Minutes()*limit.PerMinute)
+	b.last = now
+	b.limit = limit
+}
+
+// full is whether the bucket is back at capacity, and so no different
+// from a new one.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(b.limit, now)
+	return b.tokens >= b.limit.capacity()
+}
+
+// pendingSummary collects the events a device didn't get a push for.
+type pendingSummary struct {
+	pubkey    string
+	token     Pushtoken
+	limit     RateLimit
+	eventIDs  []string
+	plusCodes []string
+}
+
+// heldBroadcast is a broadcast for the tokens that were over their limit.
+type heldBroadcast struct {
+	eventID string
+	msg     exponent.Message
+	tokens  []Pushtoken
+}
+
+// pushLimiter rate limits pushes per subscriber pubkey and per device token.
+// Whatever is over the limit is coalesced into one summary per device, sent
+// as soon as the buckets allow it again. Broadcasts are held as they are
+// instead.
+type pushLimiter struct {
+	mu          sync.Mutex
+	pubkeyLimit RateLimit
+	tokenLimit  RateLimit
+	buckets     map[string]*tokenBucket
+	pending     map[string]*pendingSummary // pubkey/token -> summary
+	broadcasts  []*heldBroadcast           // oldest first
+	heldTokens  map[Pushtoken]int          // token -> broadcasts held for it
+	now         func() time.Time
+}
+
+var limiter = newPushLimiter(RateLimit{}, RateLimit{})
+
+func newPushLimiter(pubkeyLimit, tokenLimit RateLimit) *pushLimiter {
+	return &pushLimiter{
+		pubkeyLimit: pubkeyLimit,
+		tokenLimit:  tokenLimit,
+		buckets:     make(map[string]*tokenBucket),
+		pending:     make(map[string]*pendingSummary),
+		heldTokens:  make(map[Pushtoken]int),
+		now:         time.Now,
+	}
+}
+
+func (l *pushLimiter) bucket(key string, limit RateLimit, now time.Time) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: limit.capacity(), last: now}
+		l.buckets[key] = b
+	}
+	b.refill(limit, now)
+	return b
+}
+
+func (l *pushLimiter) available(key string, limit RateLimit, now time.Time) bool {
+	return limit.unlimited() || l.bucket(key, limit, now).tokens >= 1
+}
+
+func (l *pushLimiter) take(key string, limit RateLimit, now time.Time) {
+	if !limit.unlimited() {
+		l.bucket(key, limit, now).tokens--
+	}
+}
+
+// Allow returns the tokens that may get a push for event right now. The
+// others get the event added to their pending summary.
+func (l *pushLimiter) Allow(pubkey string, tokens []Pushtoken, own *RateLimit, event nostr.Event) []Pushtoken {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	pubkeyLimit := l.pubkeyLimit.stricter(own)
+	pubkeyKey := "pubkey:" + pubkey
+
+	if !l.available(pubkeyKey, pubkeyLimit, now) {
+		log.Printf("🚦 Pubkey %s is over its limit of %s, coalescing", pubkey, pubkeyLimit)
+		for _, token := range tokens {
+			l.coalesce(pubkey, token, pubkeyLimit, event)
+		}
+		return nil
+	}
+
+	var allowed []Pushtoken
+	for _, token := range tokens {
+		tokenKey := "token:" + string(token)
+		// once a summary is pending, new events join it to keep the order
+		if _, pending := l.pending[pubkey+"/"+string(token)]; pending || !l.available(tokenKey, l.tokenLimit, now) {
+			log.Printf("🚦 Token %s is over its limit of %s, coalescing", token, l.tokenLimit)
+			l.coalesce(pubkey, token, pubkeyLimit, event)
+			continue
+		}
+		l.take(tokenKey, l.tokenLimit, now)
+		allowed = append(allowed, token)
+	}
+	if len(allowed) > 0 {
+		l.take(pubkeyKey, pubkeyLimit, now)
+	}
+	return allowed
+}
+
+func (l *pushLimiter) coalesce(pubkey string, token Pushtoken, limit RateLimit, event nostr.Event) {
+	key := pubkey + "/" + string(token)
+	s, ok := l.pending[key]
+	if !ok {
+		s = &pendingSummary{pubkey: pubkey, token: token}
+		l.pending[key] = s
+	}
+	s.limit = limit
+	s.eventIDs = append(s.eventIDs, event.ID)
+	s.plusCodes = append(s.plusCodes, plusCodeFromTags(event))
+}
+
+// AllowBroadcast returns the tokens that may get a broadcast right now. Only
+// the token limit applies, as a broadcast is not from any of the subscribers'
+// filters. The others get msg later, after the broadcasts held before it.
+func (l *pushLimiter) AllowBroadcast(eventID string, tokens []Pushtoken, msg exponent.Message) []Pushtoken {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var allowed, held []Pushtoken
+	for _, token := range tokens {
+		tokenKey := "token:" + string(token)
+		if l.heldTokens[token] > 0 || !l.available(tokenKey, l.tokenLimit, now) {
+			l.heldTokens[token]++
+			held = append(held, token)
+			continue
+		}
+		l.take(tokenKey, l.tokenLimit, now)
+		allowed = append(allowed, token)
+	}
+	if len(held) > 0 {
+		log.Printf("🚦 %d tokens are over their limit of %s, holding broadcast %s", len(held), l.tokenLimit, eventID)
+		l.broadcasts = append(l.broadcasts, &heldBroadcast{eventID: eventID, msg: msg, tokens: held})
+	}
+	return allowed
+}
+
+// dueBroadcasts takes the held broadcasts for the tokens whose buckets have
+// room again, each for only those tokens.
+func (l *pushLimiter) dueBroadcasts() []*heldBroadcast {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var res []*heldBroadcast
+	// a token still waiting for one broadcast waits with the later ones
+	waiting := make(map[Pushtoken]bool)
+	kept := l.broadcasts[:0]
+	for _, b := range l.broadcasts {
+		var ready, held []Pushtoken
+		for _, token := range b.tokens {
+			tokenKey := "token:" + string(token)
+			if waiting[token] || !l.available(tokenKey, l.tokenLimit, now) {
+				waiting[token] = true
+				held = append(held, token)
+				continue
+			}
+			l.take(tokenKey, l.tokenLimit, now)
+			if l.heldTokens[token]--; l.heldTokens[token] == 0 {
+				delete(l.heldTokens, token)
+			}
+			ready = append(ready, token)
+		}
+		if len(ready) > 0 {
+			res = append(res, &heldBroadcast{eventID: b.eventID, msg: b.msg, tokens: ready})
+		}
+		if len(held) > 0 {
+			b.tokens = held
+			kept = append(kept, b)
+		}
+	}
+	clear(l.broadcasts[len(kept):])
+	l.broadcasts = kept
+	return res
+}
+
+// due takes the summaries whose buckets have room again.
+func (l *pushLimiter) due() []*pendingSummary {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var res []*pendingSummary
+	tookPubkey := make(map[string]bool)
+	for key, s := range l.pending {
+		pubkeyKey := "pubkey:" + s.pubkey
+		tokenKey := "token:" + string(s.token)
+		if !tookPubkey[s.pubkey] && !l.available(pubkeyKey, s.limit, now) {
+			continue
+		}
+		if !l.available(tokenKey, l.tokenLimit, now) {
+			continue
+		}
+		// one summary round costs a subscriber one push, not one per device
+		if !tookPubkey[s.pubkey] {
+			l.take(pubkeyKey, s.limit, now)
+			tookPubkey[s.pubkey] = true
+		}
+		l.take(tokenKey, l.tokenLimit, now)
+		delete(l.pending, key)
+		res = append(res, s)
+	}
+
+	// full buckets carry no information, forget them
+	for key, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+	return res
+}
+
+// flushSummariesEvery sends the pending summaries and held broadcasts in the
+// background.
+func flushSummariesEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, b := range limiter.dueBroadcasts() {
+			log.Printf("📣 Sending held broadcast %s to %d tokens", b.eventID, len(b.tokens))
+			sendPush(b.tokens, b.msg)
+		}
+		for _, s := range limiter.due() {
+			sendSummaryPush(s)
+		}
+	}
+}
+
+func sendSummaryPush(s *pendingSummary) {
+	log.Printf("📦 Sending summary of %d events to %s for pubkey %s", len(s.eventIDs), s.token, s.pubkey)
+	sendPush([]Pushtoken{s.token}, summaryMessage("", s.eventIDs, s.plusCodes))
+}
+
+const maxSummaryEventIDs = 40
+
+// summaryMessage groups several events into one notification, e.g.
+// "7 new notes near 9F4M". what is prepended to the title if given.
+func summaryMessage(what string, eventIDs []string, plusCodes []string) exponent.Message {
+	count := len(eventIDs)
+	title := fmt.Sprintf("%d new notes", count)
+	if count == 1 {
+		title = "1 new note"
+	}
+	if area := commonArea(plusCodes); area != "" {
+		title = fmt.Sprintf("%s near %s", title, area)
+	}
+	if what != "" {
+		title = fmt.Sprintf("%s: %s", what, title)
+	}
+
+	// the whole payload must stay below 4096 bytes, keep the newest ids
+	if len(eventIDs) > maxSummaryEventIDs {
+		eventIDs = eventIDs[len(eventIDs)-maxSummaryEventIDs:]
+	}
+
+	return exponent.Message{
+		Title:    title,
+		Body:     "Open the app to see them.",
+		Priority: exponent.DefaultPriority,
+		Data: exponent.Data{
+			"type":     "summary",
+			"count":    fmt.Sprint(count),
+			"eventIds": strings.Join(eventIDs, ","),
+		},
+	}
+}
+
+// commonArea is the shared prefix of some plus codes without the padding,
+// e.g. "9F4M" for "9F4MGC22+" and "9F4MGQ00+".
+func commonArea(plusCodes []string) string {
+	var prefix string
+	for i, code := range plusCodes {
+		if code == "unknown" {
+			return ""
+		}
+		if i == 0 {
+			prefix = code
+			continue
+		}
+		n := 0
+		for n < len(prefix) && n < len(code) && prefix[n] == code[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	prefix = strings.TrimRight(prefix, "0+")
+	if len(prefix) < 2 {
+		return ""
+	}
+	return prefix
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/9ssi7/exponent"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// testLimiter is a pushLimiter on a clock the test moves.
+func testLimiter(pubkeyLimit, tokenLimit RateLimit) (*pushLimiter, func(time.Duration)) {
+	l := newPushLimiter(pubkeyLimit, tokenLimit)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestPushLimiterBurst(t *testing.T) {
+	l, _ := testLimiter(RateLimit{}, RateLimit{PerMinute: 1, Burst: 3})
+	for i := range 3 {
+		if allowed := l.Allow("alice", []Pushtoken{"phone"}, nil, nostr.Event{ID: "ok"}); len(allowed) != 1 {
+			t.Fatalf("push %d within burst was not allowed", i+1)
+		}
+	}
+	if allowed := l.Allow("alice", []Pushtoken{"phone"}, nil, nostr.Event{ID: "over"}); len(allowed) != 0 {
+		t.Fatalf("push over burst was allowed")
+	}
+	s := l.pending["alice/phone"]
+	if s == nil || !slices.Equal(s.eventIDs, []string{"over"}) {
+		t.Fatalf("pending summary = %+v, want the event over the limit", s)
+	}
+}
+
+func TestPushLimiterRefill(t *testing.T) {
+	l, advance := testLimiter(RateLimit{PerMinute: 2, Burst: 1}, RateLimit{})
+	allow := func() bool {
+		return len(l.Allow("alice", []Pushtoken{"phone"}, nil, nostr.Event{})) == 1
+	}
+	if !allow() {
+		t.Fatalf("first push was not allowed")
+	}
+	delete(l.pending, "alice/phone")
+
+	advance(20 * time.Second)
+	if allow() {
+		t.Fatalf("push allowed before the bucket refilled")
+	}
+	if len(l.due()) != 0 {
+		t.Fatalf("summary due before the bucket refilled")
+	}
+
+	advance(10 * time.Second)
+	if due := l.due(); len(due) != 1 || due[0].token != "phone" {
+		t.Fatalf("due = %v, want the summary once the bucket refilled", due)
+	}
+	if allow() {
+		t.Fatalf("push allowed although the summary took the refill")
+	}
+}
+
+func TestPushLimiterForgetsOnlyFullBuckets(t *testing.T) {
+	// one push per 100 minutes
+	l, advance := testLimiter(RateLimit{}, RateLimit{PerMinute: 0.01, Burst: 2})
+	l.Allow("alice", []Pushtoken{"phone"}, nil, nostr.Event{})
+	l.Allow("alice", []Pushtoken{"phone"}, nil, nostr.Event{})
+
+	// idle for hours, but not refilled yet
+	advance(2 * time.Hour)
+	l.due()
+	if _, ok := l.buckets["token:phone"]; !ok {
+		t.Fatalf("bucket forgotten before it refilled")
+	}
+
+	advance(90 * time.Minute)
+	l.due()
+	if _, ok := l.buckets["token:phone"]; ok {
+		t.Fatalf("full bucket kept")
+	}
+	if allowed := l.Allow("alice", []Pushtoken{"phone"}, nil, nostr.Event{}); len(allowed) != 1 {
+		t.Fatalf("push not allowed after the bucket was forgotten")
+	}
+}
+
+func TestPushLimiterHoldsBroadcasts(t *testing.T) {
+	l, advance := testLimiter(RateLimit{}, RateLimit{PerMinute: 1, Burst: 1})
+	l.Allow("alice", []Pushtoken{"phone"}, nil, nostr.Event{})
+
+	first := exponent.Message{Title: "first"}
+	second := exponent.Message{Title: "second"}
+	if allowed := l.AllowBroadcast("b1", []Pushtoken{"phone", "tablet"}, first); !slices.Equal(allowed, []Pushtoken{"tablet"}) {
+		t.Fatalf("allowed = %v, want the token under its limit", allowed)
+	}
+	// tablet has used up its limit now, phone waits for the first one
+	if allowed := l.AllowBroadcast("b2", []Pushtoken{"phone", "tablet"}, second); len(allowed) != 0 {
+		t.Fatalf("allowed = %v, want none", allowed)
+	}
+	if due := l.dueBroadcasts(); len(due) != 0 {
+		t.Fatalf("due = %v before the buckets refilled", due)
+	}
+
+	type delivery struct {
+		title  string
+		tokens []Pushtoken
+	}
+	deliver := func() []delivery {
+		var res []delivery
+		for _, b := range l.dueBroadcasts() {
+			res = append(res, delivery{b.msg.Title, b.tokens})
+		}
+		return res
+	}
+	for i, want := range [][]delivery{
+		{{"first", []Pushtoken{"phone"}}, {"second", []Pushtoken{"tablet"}}},
+		{{"second", []Pushtoken{"phone"}}},
+		nil,
+	} {
+		advance(time.Minute)
+		if got := deliver(); !slices.EqualFunc(got, want, func(a, b delivery) bool {
+			return a.title == b.title && slices.Equal(a.tokens, b.tokens)
+		}) {
+			t.Fatalf("minute %d: delivered %v, want %v", i+1, got, want)
+		}
+	}
+	if len(l.heldTokens) != 0 {
+		t.Errorf("heldTokens = %v, want none left", l.heldTokens)
+	}
+}
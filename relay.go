@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// relayIngest replaces RabbitMQ by holding live subscriptions on one or more
+// NIP-01 relays. Relay connections run in their own goroutines, but all
+// events are funneled into a single loop so the managers are only ever
+// touched from one goroutine.
+type relayIngest struct {
+	urls           []string
+	reconnectDelay time.Duration
+
+	events chan nostr.Event
+
+	// lastSeen is the newest created_at we processed, used as `since` when
+	// (re)subscribing so nothing published in between is missed.
+	lastSeen atomic.Int64
+
+	mu      sync.Mutex
+	filters nostr.Filters
+	changed chan struct{} // closed and replaced whenever filters change
+}
+
+func newRelayIngest(urls []string, reconnectDelay time.Duration, since nostr.Timestamp) *relayIngest {
+	ri := &relayIngest{
+		urls:           urls,
+		reconnectDelay: reconnectDelay,
+		events:         make(chan nostr.Event),
+		changed:        make(chan struct{}),
+	}
+	ri.lastSeen.Store(int64(since))
+	return ri
+}
+
+// relayFilters is the union of all active filters plus the filters for
+// subscription updates addressed to us, the subscribers' mute lists and
+// operator broadcasts.
+func relayFilters(fm *FilterManager) nostr.Filters {
+	filters := nostr.Filters{
+		nostr.Filter{
+			Kinds: []int{KindAppData, KindDeviceAppData},
+			Tags:  nostr.TagMap{"p": keys.publicKeys()},
+		},
+	}
+	if len(operatorPubkeys) > 0 {
+		filters = append(filters, nostr.Filter{
+			Kinds:   []int{KindBroadcast},
+			Authors: operatorPubkeys,
+		})
+	}
+	if pubkeys := fm.GetAllPubkeys(); len(pubkeys) > 0 {
+		filters = append(filters, nostr.Filter{
+			Kinds:   []int{KindMuteList},
+			Authors: pubkeys,
+		})
+	}
+	for _, f := range fm.GetAllFilters() {
+		f = f.Clone()
+		f.Limit = 0
+		// search is matched locally, not every relay supports NIP-50
+		f.Search = ""
+		filters = append(filters, f)
+	}
+	return filters
+}
+
+func (ri *relayIngest) setFilters(filters nostr.Filters) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	ri.filters = filters
+	close(ri.changed)
+	ri.changed = make(chan struct{})
+}
+
+func (ri *relayIngest) currentFilters() (nostr.Filters, <-chan struct{}) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	return ri.filters, ri.changed
+}
+
+// withSince returns a copy of filters that only asks for events newer than
+// since, unless a filter already starts later.
+func withSince(filters nostr.Filters, since nostr.Timestamp) nostr.Filters {
+	res := make(nostr.Filters, 0, len(filters))
+	for _, f := range filters {
+		f = f.Clone()
+		if f.Since == nil || *f.Since < since {
+			s := since
+			f.Since = &s
+		}
+		res = append(res, f)
+	}
+	return res
+}
+
+func (ri *relayIngest) run(url string) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		relay, err := nostr.RelayConnect(ctx, url)
+		cancel()
+		if err != nil {
+			log.Printf("❌ Failed to connect to relay %s: %v. Retrying in %s", url, err, ri.reconnectDelay)
+			time.Sleep(ri.reconnectDelay)
+			continue
+		}
+		log.Printf("🔌 Connected to relay %s", url)
+
+		ri.consume(relay)
+		relay.Close()
+
+		log.Printf("🔌 Lost connection to relay %s. Reconnecting in %s", url, ri.reconnectDelay)
+		time.Sleep(ri.reconnectDelay)
+	}
+}
+
+// consume (re)subscribes on relay until the connection drops.
+func (ri *relayIngest) consume(relay *nostr.Relay) {
+	for {
+		filters, changed := ri.currentFilters()
+		since := nostr.Timestamp(ri.lastSeen.Load())
+
+		sub, err := relay.Subscribe(relay.Context(), withSince(filters, since))
+		if err != nil {
+			log.Printf("❌ Failed to subscribe on %s: %v", relay.URL, err)
+			return
+		}
+		log.Printf("📡 Subscribed on %s with %d filters since %d", relay.URL, len(filters), since)
+
+		resubscribe := false
+		for !resubscribe {
+			select {
+			case ev, ok := <-sub.Events:
+				if !ok {
+					return
+				}
+				// a relay may hand us anything, e.g. a 10395 forged for
+				// someone else, but go-nostr already dropped events with
+				// invalid signatures since we leave AssumeValid off
+				ri.events <- *ev
+			case reason := <-sub.ClosedReason:
+				log.Printf("⛔ Relay %s closed our subscription: %s", relay.URL, reason)
+				sub.Unsub()
+				return
+			case <-changed:
+				resubscribe = true
+			case <-relay.Context().Done():
+				return
+			}
+		}
+		sub.Unsub()
+	}
+}
+
+// readRelays is the relay counterpart of readRabbitMQ. It never returns.
+func readRelays(ri *relayIngest, reg *Registry) error {
+	filters := 0
+	reg.watch(func() error {
+		filters = len(reg.fm.GetAllFilters())
+		ri.setFilters(relayFilters(reg.fm))
+		return nil
+	})
+
+	for _, url := range ri.urls {
+		go ri.run(url)
+	}
+
+	log.Printf("Starting to consume events from %d relays with %d filters",
+		len(ri.urls),
+		filters)
+
+	// copies of an event delivered by more than one relay are dropped by
+	// the seen event store in processEvent
+	for event := range ri.events {
+		processEvent(reg, event, "relay")
+
+		// an event dated in the future would have us resubscribe with a
+		// since that hides everything until then
+		createdAt := min(int64(event.CreatedAt), time.Now().Unix())
+		if createdAt > ri.lastSeen.Load() {
+			ri.lastSeen.Store(createdAt)
+		}
+	}
+
+	return nil
+}
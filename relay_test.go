@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestWithSince(t *testing.T) {
+	ts := func(v nostr.Timestamp) *nostr.Timestamp { return &v }
+
+	tests := []struct {
+		name  string
+		since *nostr.Timestamp
+		want  nostr.Timestamp
+	}{
+		{"no since", nil, 100},
+		{"earlier since", ts(50), 100},
+		{"later since", ts(150), 150},
+		{"same since", ts(100), 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters := nostr.Filters{{Kinds: []int{1}, Since: tt.since}}
+			got := withSince(filters, 100)
+
+			if *got[0].Since != tt.want {
+				t.Errorf("since = %d, want %d", *got[0].Since, tt.want)
+			}
+			if tt.since != nil && *filters[0].Since != *tt.since {
+				t.Errorf("original filter changed to %d", *filters[0].Since)
+			}
+		})
+	}
+}
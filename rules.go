@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/conf"
+	"github.com/expr-lang/expr/vm"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Limits for subscriber supplied rules, so a rule can't slow the matcher.
+var (
+	maxRuleLength = 500
+	maxRuleNodes  = uint(100)
+	ruleMemory    = uint(10000)
+	maxRuleCost   = 2000.0
+	// ruleLoopSize is what a predicate like any(tags, ...) is assumed to
+	// iterate over, for the cost
+	ruleLoopSize = 100.0
+)
+
+// ruleBuiltins are the only builtins a rule can call: string helpers and
+// predicates. Builtins like repeat, map or split could allocate more than a
+// rule should.
+var ruleBuiltins = []string{
+	"len", "trim", "trimPrefix", "trimSuffix", "upper", "lower",
+	"hasPrefix", "hasSuffix", "indexOf", "lastIndexOf",
+	"all", "any", "none", "one", "count",
+}
+
+// ruleEnv is what a filter rule can see of an event, e.g.
+//
+//	kind == 1 && ("hosting" in hashtags || len(content) > 20) && pubkey not in ["<hex>"]
+type ruleEnv struct {
+	ID        string     `expr:"id"`
+	Pubkey    string     `expr:"pubkey"`
+	Kind      int        `expr:"kind"`
+	CreatedAt int64      `expr:"created_at"`
+	Content   string     `expr:"content"`
+	Tags      [][]string `expr:"tags"`
+	Hashtags  []string   `expr:"hashtags"`
+	PlusCode  string     `expr:"pluscode"`
+}
+
+func newRuleEnv(event *nostr.Event) ruleEnv {
+	tags := make([][]string, 0, len(event.Tags))
+	for _, tag := range event.Tags {
+		tags = append(tags, tag)
+	}
+	return ruleEnv{
+		ID:        event.ID,
+		Pubkey:    event.PubKey,
+		Kind:      event.Kind,
+		CreatedAt: int64(event.CreatedAt),
+		Content:   event.Content,
+		Tags:      tags,
+		Hashtags:  GetTagValues(*event, "t"),
+		PlusCode:  plusCodeFromTags(*event),
+	}
+}
+
+func withRuleLimits(c *conf.Config) {
+	c.MaxNodes = maxRuleNodes
+	for name := range c.Builtins {
+		c.Disabled[name] = true
+	}
+	for _, name := range ruleBuiltins {
+		delete(c.Disabled, name)
+	}
+}
+
+// disallowedBuiltin finds a builtin that is not in ruleBuiltins. Predicates
+// like map and filter are parsed even when disabled, so the compiled rule
+// is checked as well.
+func disallowedBuiltin(node ast.Node) string {
+	var name string
+	ast.Walk(&node, visitor(func(n *ast.Node) {
+		if b, ok := (*n).(*ast.BuiltinNode); ok && !slices.Contains(ruleBuiltins, b.Name) {
+			name = b.Name
+		}
+	}))
+	return name
+}
+
+// ruleCost estimates how much work a rule is per event: one per node, times
+// ruleLoopSize for every predicate it is nested in, plus the size of
+// constant ranges like 1..1000.
+func ruleCost(node ast.Node) float64 {
+	depth := make(map[ast.Node]int)
+	ast.Walk(&node, visitor(func(n *ast.Node) {
+		if p, ok := (*n).(*ast.PredicateNode); ok {
+			ast.Walk(&p.Node, visitor(func(inner *ast.Node) {
+				depth[*inner]++
+			}))
+		}
+	}))
+
+	cost := 0.0
+	ast.Walk(&node, visitor(func(n *ast.Node) {
+		cost += math.Pow(ruleLoopSize, float64(depth[*n]))
+		if b, ok := (*n).(*ast.BinaryNode); ok && b.Operator == ".." {
+			from, fromOK := b.Left.(*ast.IntegerNode)
+			to, toOK := b.Right.(*ast.IntegerNode)
+			if fromOK && toOK && to.Value > from.Value {
+				cost += float64(to.Value - from.Value)
+			}
+		}
+	}))
+	return cost
+}
+
+type visitor func(node *ast.Node)
+
+func (v visitor) Visit(node *ast.Node) { v(node) }
+
+// compileRule checks a rule once when the subscription comes in, so bad or
+// oversized rules are rejected up front instead of failing on every event.
+func compileRule(rule string) (*vm.Program, error) {
+	if len(rule) > maxRuleLength {
+		return nil, fmt.Errorf("rule is longer than %d characters", maxRuleLength)
+	}
+	program, err := expr.Compile(rule, expr.Env(ruleEnv{}), expr.AsBool(), withRuleLimits)
+	if err != nil {
+		return nil, err
+	}
+	if name := disallowedBuiltin(program.Node()); name != "" {
+		return nil, fmt.Errorf("%s can't be used in rules", name)
+	}
+	if cost := ruleCost(program.Node()); cost > maxRuleCost {
+		return nil, fmt.Errorf("rule is too expensive, cost %g is over %g", cost, maxRuleCost)
+	}
+	return program, nil
+}
+
+// runRule treats a rule that fails at runtime, e.g. by running out of its
+// memory budget, as not matching.
+func runRule(program *vm.Program, event *nostr.Event) (bool, error) {
+	// the budget from the compile config is not carried over to expr.Run
+	machine := vm.VM{MemoryBudget: ruleMemory}
+	out, err := machine.Run(program, newRuleEnv(event))
+	if err != nil {
+		return false, err
+	}
+	return out.(bool), nil
+}
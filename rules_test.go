@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestCompileRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		wantErr bool
+	}{
+		{"simple", `kind == 1`, false},
+		{"hashtags and content", `"nostr" in hashtags || len(content) > 20`, false},
+		{"not boolean", `kind + 1`, true},
+		{"unknown field", `sender == "x"`, true},
+		{"syntax error", `kind ==`, true},
+		{"too long", `content == "` + strings.Repeat("a", maxRuleLength) + `"`, true},
+		{"too many nodes", strings.Repeat("kind == 1 || ", 60) + "kind == 1", true},
+		{"predicate", `any(tags, {#[0] == "t" && #[1] == "nostr"})`, false},
+		{"repeat", `len(repeat(content, 1000000)) > 0`, true},
+		{"allocating builtin", `len(map(tags, {#[0]})) > 0`, true},
+		{"nested predicates", `any(tags, {any(tags, {any(tags, {# == #})})})`, true},
+		{"large range", `any(1..100000, {# == kind})`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileRule(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("compileRule(%q) error = %v, wantErr %v", tt.rule, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunRule(t *testing.T) {
+	event := &nostr.Event{
+		ID:        "id1",
+		PubKey:    "author",
+		Kind:      1,
+		CreatedAt: 1700000000,
+		Content:   "hello world",
+		Tags:      nostr.Tags{{"t", "nostr"}, {"l", "8FVC9G8F+6X", "open-location-code"}},
+	}
+	tests := []struct {
+		name    string
+		rule    string
+		want    bool
+		wantErr bool
+	}{
+		{"kind", `kind == 1`, true, false},
+		{"other kind", `kind == 7`, false, false},
+		{"hashtag", `"nostr" in hashtags`, true, false},
+		{"pubkey excluded", `pubkey not in ["author"]`, false, false},
+		{"content", `content contains "world"`, true, false},
+		{"pluscode", `pluscode startsWith "8FVC"`, true, false},
+		{"created_at", `created_at > 1600000000`, true, false},
+		{"tags", `any(tags, {#[0] == "t"})`, true, false},
+		{"over memory budget", `len(1..len(content)*1000) > 0`, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := compileRule(tt.rule)
+			if err != nil {
+				t.Fatalf("compileRule(%q): %v", tt.rule, err)
+			}
+			got, err := runRule(program, event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("runRule(%q) error = %v, wantErr %v", tt.rule, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("runRule(%q) = %v, want %v", tt.rule, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFiltersRefusesExpensiveRules(t *testing.T) {
+	event := nostr.Event{Kind: KindAppData, Content: `{"filters": [
+		{"filter": {"kinds": [1]}, "rule": "len(repeat(content, 1000000)) > 0"},
+		{"filter": {"kinds": [1]}, "rule": "kind == 1"}
+	]}`}
+	filters, rejections := parseFilters([]nostr.Event{event})
+	if len(filters) != 1 || filters[0].Rule != "kind == 1" {
+		t.Errorf("filters = %+v, want only the cheap rule", filters)
+	}
+	if len(rejections) != 1 || rejections[0].Field != "filters[0].rule" {
+		t.Errorf("rejections = %+v, want the repeat rule refused", rejections)
+	}
+}
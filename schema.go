@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/9ssi7/exponent"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// subscriptionVersion is the newest 10395 content version we understand.
+// Content without a version is treated as version 1.
+const subscriptionVersion = 1
+
+// maxTokenLength is far above what Expo hands out.
+const maxTokenLength = 256
+
+// SubscriptionLimits are the operator's limits for a single subscriber.
+// Filters and tokens are counted per device. Zero means unlimited.
+type SubscriptionLimits struct {
+	MaxFilters int
+	MaxTokens  int
+	MaxDevices int
+
+	// MaxPubkeysPerToken bounds how many pubkeys may register one token.
+	MaxPubkeysPerToken int
+
+	// AllowBroadFilters lets through filters without kinds, authors, ids
+	// or tags, which match every event on the relay.
+	AllowBroadFilters bool
+}
+
+var subscriptionLimits SubscriptionLimits
+
+func loadSubscriptionLimits() SubscriptionLimits {
+	return SubscriptionLimits{
+		MaxFilters:         getEnvInt("SUBSCRIPTION_MAX_FILTERS", 20),
+		MaxTokens:          getEnvInt("SUBSCRIPTION_MAX_TOKENS", 5),
+		MaxDevices:         getEnvInt("SUBSCRIPTION_MAX_DEVICES", 10),
+		MaxPubkeysPerToken: getEnvInt("SUBSCRIPTION_MAX_PUBKEYS_PER_TOKEN", 3),
+		AllowBroadFilters:  getEnvBool("SUBSCRIPTION_ALLOW_BROAD_FILTERS", false),
+	}
+}
+
+// Rejection explains why part of a subscription was dropped or changed.
+// Field points into the 10395 content, e.g. "filters[2].quietHours".
+type Rejection struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func reject(rejections *[]Rejection, field, format string, args ...any) {
+	r := Rejection{Field: field, Reason: fmt.Sprintf(format, args...)}
+	log.Printf("🚫 Rejected %s: %s", r.Field, r.Reason)
+	*rejections = append(*rejections, r)
+}
+
+// checkVersion rejects content written for a newer schema than ours, so a
+// client update can't be misread by an older daemon.
+func checkVersion(event nostr.Event) *Rejection {
+	var content struct {
+		Version *int `json:"version"`
+	}
+	if err := json.Unmarshal([]byte(event.Content), &content); err != nil {
+		return &Rejection{Field: "content", Reason: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	if content.Version == nil {
+		return nil
+	}
+	if *content.Version < 1 || *content.Version > subscriptionVersion {
+		return &Rejection{Field: "version", Reason: fmt.Sprintf("unsupported version %d, this daemon supports up to %d", *content.Version, subscriptionVersion)}
+	}
+	return nil
+}
+
+// isBroadFilter reports filters that would match every event on the relay.
+func isBroadFilter(f nostr.Filter) bool {
+	return len(f.IDs) == 0 && len(f.Kinds) == 0 && len(f.Authors) == 0 && len(f.Tags) == 0
+}
+
+func validateToken(token string) error {
+	if len(token) > maxTokenLength {
+		return fmt.Errorf("token is longer than %d characters", maxTokenLength)
+	}
+	if !exponent.IsPushTokenValid(token) {
+		return fmt.Errorf("not an Expo push token")
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// searchQuery is a compiled NIP-50 `search` string. Words must all appear in
+// the content, "quoted phrases" as a whole, and -negated terms must not.
+// Extensions like `language:en` are not supported and ignored.
+type searchQuery struct {
+	include []string
+	exclude []string
+}
+
+// normalizeText folds case and strips diacritics, so "Café" matches "cafe",
+// and reduces the text to single space separated words, padded with a space
+// on both ends so terms can be matched as whole words.
+func normalizeText(s string) string {
+	t := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		folded = s
+	}
+	folded = cases.Fold().String(folded)
+
+	words := strings.FieldsFunc(folded, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return " " + strings.Join(words, " ") + " "
+}
+
+func parseSearch(search string) *searchQuery {
+	q := &searchQuery{}
+
+	var terms []string
+	var negated []bool
+	rest := strings.TrimSpace(search)
+	for rest != "" {
+		neg := false
+		if strings.HasPrefix(rest, "-") {
+			neg = true
+			rest = rest[1:]
+		}
+
+		var term string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				term, rest = rest[1:], ""
+			} else {
+				term, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			term, rest = rest[:end], rest[end:]
+			if strings.Contains(term, ":") {
+				term = ""
+			}
+		}
+		rest = strings.TrimSpace(rest)
+
+		if term = strings.TrimSpace(normalizeText(term)); term != "" {
+			terms = append(terms, term)
+			negated = append(negated, neg)
+		}
+	}
+
+	for i, term := range terms {
+		if negated[i] {
+			q.exclude = append(q.exclude, " "+term+" ")
+		} else {
+			q.include = append(q.include, " "+term+" ")
+		}
+	}
+	return q
+}
+
+func (q *searchQuery) matches(content string) bool {
+	text := normalizeText(content)
+	for _, term := range q.exclude {
+		if strings.Contains(text, term) {
+			return false
+		}
+	}
+	for _, term := range q.include {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestSearchMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		search  string
+		content string
+		want    bool
+	}{
+		{"term", "bike", "Found a bike near the station", true},
+		{"all terms", "bike station", "Found a bike near the station", true},
+		{"missing term", "bike car", "Found a bike near the station", false},
+		{"whole words only", "bike", "Bikes for sale", false},
+		{"no match", "flood", "Found a bike near the station", false},
+		{"empty content", "bike", "", false},
+		{"empty search matches everything", "", "anything", true},
+		{"phrase", `"lost cat"`, "Have you seen my lost cat?", true},
+		{"phrase words apart", `"lost cat"`, "Lost my keys, and my cat", false},
+		{"phrase and term", `"lost cat" grey`, "Lost cat, grey and small", true},
+		{"unterminated phrase", `"lost cat`, "a lost cat", true},
+		{"upper case search", "BIKE", "found a bike", true},
+		{"upper case content", "bike", "FOUND A BIKE", true},
+		{"case folding", "strasse", "STRASSE gesperrt", true},
+		{"diacritics", "cafe", "Meet at the Café", true},
+		{"diacritics in search", "café", "meet at the cafe", true},
+		{"punctuation", "station", "bike, near the station!", true},
+		{"negated term", "bike -sale", "Found a bike near the station", true},
+		{"negated term present", "bike -sale", "Bike for sale", false},
+		{"negated phrase present", `bike -"for sale"`, "Bike for sale", false},
+		{"extension ignored", "bike language:en", "Found a bike", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSearch(tt.search).matches(tt.content); got != tt.want {
+				t.Errorf("parseSearch(%q).matches(%q) = %v, want %v", tt.search, tt.content, got, tt.want)
+			}
+		})
+	}
+}
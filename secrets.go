@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	PushBackendExpo = "expo"
+	PushBackendLog  = "log" // only logs the pushes, e.g. for staging
+)
+
+// maxNcryptsecLogN keeps a crafted ncryptsec from having scrypt allocate
+// more than a few GB.
+const maxNcryptsecLogN = 22
+
+// configReport collects what startup found out about the keys and secrets,
+// so every problem is logged at once instead of one per restart.
+type configReport struct {
+	lines  []string
+	failed bool
+}
+
+func (r *configReport) ok(name, format string, args ...any) {
+	r.lines = append(r.lines, fmt.Sprintf("  ✅ %s: %s", name, fmt.Sprintf(format, args...)))
+}
+
+func (r *configReport) warn(name, format string, args ...any) {
+	r.lines = append(r.lines, fmt.Sprintf("  ⚠️ %s: %s", name, fmt.Sprintf(format, args...)))
+}
+
+func (r *configReport) fail(name, format string, args ...any) {
+	r.lines = append(r.lines, fmt.Sprintf("  ❌ %s: %s", name, fmt.Sprintf(format, args...)))
+	r.failed = true
+}
+
+// print logs the report and stops if anything failed.
+func (r *configReport) print() {
+	log.Printf("🔎 Checking configuration")
+	for _, line := range r.lines {
+		log.Print(line)
+	}
+	if r.failed {
+		log.Fatal("Configuration is invalid. Stopping")
+	}
+}
+
+// secrets are the keys and tokens from the environment, decoded and checked.
+type secrets struct {
+	pushBackends    []string
+	expoAccessToken string
+	rabbitmqURL     string // empty for the default
+
+	privateKey   string // hex, empty with a remote bunker
+	previousKeys []string
+	bunkerURL    string
+	clientKey    string // for the bunker session, may be empty
+}
+
+// readSecret reads name from the environment, or from the file name_FILE
+// points to, like Docker and Kubernetes mount secrets.
+func readSecret(name string) (value, source string, err error) {
+	value = os.Getenv(name)
+	file := os.Getenv(name + "_FILE")
+	switch {
+	case value != "" && file != "":
+		return "", "", fmt.Errorf("both %s and %s_FILE are set", name, name)
+	case file != "":
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", "", err
+		}
+		return strings.TrimSpace(string(b)), "file " + file, nil
+	case value != "":
+		return value, "env", nil
+	}
+	return "", "", nil
+}
+
+// parsePrivateKey accepts hex, nsec and ncryptsec (NIP-49) keys and returns
+// hex, plus which of them it was.
+func parsePrivateKey(value, passphrase string) (key, format string, err error) {
+	switch {
+	case strings.HasPrefix(value, "nsec1"):
+		prefix, decoded, err := nip19.Decode(value)
+		if err != nil || prefix != "nsec" {
+			return "", "", fmt.Errorf("invalid nsec: %v", err)
+		}
+		key, format = decoded.(string), "nsec"
+	case strings.HasPrefix(value, "ncryptsec1"):
+		if passphrase == "" {
+			return "", "", fmt.Errorf("ncryptsec needs PRIVATEKEY_PASSPHRASE")
+		}
+		key, err = decryptNcryptsec(value, passphrase)
+		if err != nil {
+			return "", "", err
+		}
+		format = "ncryptsec"
+	default:
+		if b, err := hex.DecodeString(value); err != nil || len(b) != 32 {
+			return "", "", fmt.Errorf("neither 64 hex characters, nsec nor ncryptsec")
+		}
+		key, format = strings.ToLower(value), "hex"
+	}
+	// GetPublicKey doesn't mind a key of zero or above the curve order
+	var scalar btcec.ModNScalar
+	if b, err := hex.DecodeString(key); err != nil || len(b) != 32 || scalar.SetByteSlice(b) || scalar.IsZero() {
+		return "", "", fmt.Errorf("not a valid key: out of range")
+	}
+	if _, err := nostr.GetPublicKey(key); err != nil {
+		return "", "", fmt.Errorf("not a valid key: %v", err)
+	}
+	return key, format, nil
+}
+
+// decryptNcryptsec decrypts a NIP-49 encrypted private key.
+func decryptNcryptsec(ncryptsec, passphrase string) (string, error) {
+	prefix, bits5, err := bech32.DecodeNoLimit(ncryptsec)
+	if err != nil || prefix != "ncryptsec" {
+		return "", fmt.Errorf("invalid ncryptsec: %v", err)
+	}
+	data, err := bech32.ConvertBits(bits5, 5, 8, false)
+	if err != nil {
+		return "", fmt.Errorf("invalid ncryptsec: %v", err)
+	}
+	// version, log_n, salt, nonce, key security byte, ciphertext
+	if len(data) != 1+1+16+24+1+48 || data[0] != 0x02 {
+		return "", fmt.Errorf("unsupported ncryptsec version")
+	}
+	logN := data[1]
+	salt, nonce, keySecurity, ciphertext := data[2:18], data[18:42], data[42:43], data[43:]
+	if logN > maxNcryptsecLogN {
+		return "", fmt.Errorf("ncryptsec log_n %d is above %d", logN, maxNcryptsecLogN)
+	}
+
+	symmetric, err := scrypt.Key([]byte(norm.NFKC.String(passphrase)), salt, 1<<logN, 8, 1, 32)
+	if err != nil {
+		return "", err
+	}
+	aead, err := chacha20poly1305.NewX(symmetric)
+	if err != nil {
+		return "", err
+	}
+	key, err := aead.Open(nil, nonce, ciphertext, keySecurity)
+	if err != nil {
+		return "", fmt.Errorf("wrong passphrase for ncryptsec")
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// loadKey reads and decodes the key in name, reporting its pubkey.
+func loadKey(report *configReport, name, passphrase string, required bool) string {
+	value, source, err := readSecret(name)
+	switch {
+	case err != nil:
+		report.fail(name, "%v", err)
+		return ""
+	case value == "":
+		if required {
+			report.fail(name, "not set")
+		}
+		return ""
+	}
+	key, format, err := parsePrivateKey(value, passphrase)
+	if err != nil {
+		report.fail(name, "from %s: %v", source, err)
+		return ""
+	}
+	report.ok(name, "%s from %s, publickey %s", format, source, derivePublickey(key))
+	return key
+}
+
+// loadSecrets reads everything secret from the environment. It never
+// reports the values themselves.
+func loadSecrets(report *configReport) secrets {
+	var s secrets
+
+	s.pushBackends = getEnvList("PUSH_BACKENDS", []string{PushBackendExpo})
+	if len(s.pushBackends) == 0 {
+		report.fail("PUSH_BACKENDS", "no backend, use %q and/or %q", PushBackendExpo, PushBackendLog)
+	}
+	for _, backend := range s.pushBackends {
+		if backend != PushBackendExpo && backend != PushBackendLog {
+			report.fail("PUSH_BACKENDS", "unknown backend %q, use %q or %q", backend, PushBackendExpo, PushBackendLog)
+		}
+	}
+	token, source, err := readSecret("EXPOACCESSTOKEN")
+	switch {
+	case err != nil:
+		report.fail("EXPOACCESSTOKEN", "%v", err)
+	case !slices.Contains(s.pushBackends, PushBackendExpo):
+		report.ok("PUSH_BACKENDS", "%s, Expo is off", strings.Join(s.pushBackends, ", "))
+	case token == "":
+		report.fail("EXPOACCESSTOKEN", "not set, but needed for the %s backend", PushBackendExpo)
+	default:
+		report.ok("EXPOACCESSTOKEN", "from %s", source)
+	}
+	s.expoAccessToken = token
+
+	// the url carries the RabbitMQ credentials
+	s.rabbitmqURL, source, err = readSecret("RABBITMQ_URL")
+	if err != nil {
+		report.fail("RABBITMQ_URL", "%v", err)
+	} else if s.rabbitmqURL != "" {
+		report.ok("RABBITMQ_URL", "from %s", source)
+	}
+
+	passphrase, source, err := readSecret("PRIVATEKEY_PASSPHRASE")
+	if err != nil {
+		report.fail("PRIVATEKEY_PASSPHRASE", "%v", err)
+	} else if passphrase != "" {
+		report.ok("PRIVATEKEY_PASSPHRASE", "from %s", source)
+	}
+
+	s.bunkerURL, source, err = readSecret("BUNKER_URL")
+	switch {
+	case err != nil:
+		report.fail("BUNKER_URL", "%v", err)
+	case s.bunkerURL == "local":
+		report.warn("BUNKER_URL", "local stand-in bunker, the key is still on this host")
+	case s.bunkerURL != "":
+		if remote, _, _, err := parseBunkerURL(s.bunkerURL); err != nil {
+			report.fail("BUNKER_URL", "from %s: %v", source, err)
+		} else {
+			report.ok("BUNKER_URL", "bunker %s from %s", remote, source)
+		}
+	}
+
+	// a remote bunker holds the key, the local one needs it
+	remote := s.bunkerURL != "" && s.bunkerURL != "local"
+	s.privateKey = loadKey(report, "PRIVATEKEY", passphrase, !remote)
+	if remote && s.privateKey != "" {
+		report.warn("PRIVATEKEY", "ignored, the bunker holds the key")
+		s.privateKey = ""
+	}
+	if remote {
+		s.clientKey = loadKey(report, "BUNKER_CLIENT_KEY", passphrase, false)
+	}
+
+	previous, source, err := readSecret("PREVIOUS_PRIVATEKEYS")
+	if err != nil {
+		report.fail("PREVIOUS_PRIVATEKEYS", "%v", err)
+	}
+	for i, value := range strings.Split(previous, ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		name := fmt.Sprintf("PREVIOUS_PRIVATEKEYS[%d]", i)
+		key, format, err := parsePrivateKey(value, passphrase)
+		if err != nil {
+			report.fail(name, "from %s: %v", source, err)
+			continue
+		}
+		report.ok(name, "%s from %s, publickey %s", format, source, derivePublickey(key))
+		s.previousKeys = append(s.previousKeys, key)
+	}
+
+	return s
+}
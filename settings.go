@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// SubscriberSettings holds the options from the 10395 or 30395 content that
+// are not filters or tokens. They apply to the tokens of that device only.
+type SubscriberSettings struct {
+	// RateLimit lets a subscriber ask for fewer pushes than the operator
+	// allows. It can't be used to get more.
+	RateLimit *RateLimit `json:"rateLimit,omitempty"`
+
+	// Timezone is an IANA name like "Europe/Berlin", used for QuietHours.
+	Timezone string `json:"timezone,omitempty"`
+
+	// QuietHours apply to all filters that don't bring their own.
+	QuietHours *QuietHours `json:"quietHours,omitempty"`
+
+	// OtherKeys are further pubkeys of the subscriber whose events, like
+	// their own, don't notify them unless NotifyOwnEvents is set.
+	OtherKeys       []string `json:"otherKeys,omitempty"`
+	NotifyOwnEvents bool     `json:"notifyOwnEvents,omitempty"`
+
+	// Locale like "de-DE" is used to target operator broadcasts.
+	Locale string `json:"locale,omitempty"`
+}
+
+type SettingsManager struct {
+	// settingsByDevice holds the settings of each device of a pubkey, the
+	// 10395 being device ""
+	settingsByDevice map[string]map[string]SubscriberSettings
+}
+
+func NewSettingsManager() *SettingsManager {
+	return &SettingsManager{
+		settingsByDevice: make(map[string]map[string]SubscriberSettings),
+	}
+}
+
+func (sm *SettingsManager) UpdateSettings(event nostr.Event) []Rejection {
+	if !isAppData(event.Kind) {
+		return nil
+	}
+
+	settings, rejections, err := parseSettings(event)
+	if err != nil {
+		log.Printf("❌ Failed to parse settings from pubkey %s: %v", event.PubKey, err)
+		return []Rejection{{Field: "settings", Reason: fmt.Sprintf("ignored: %v", err)}}
+	}
+	device := deviceOf(event)
+	if sm.settingsByDevice[event.PubKey] == nil {
+		sm.settingsByDevice[event.PubKey] = make(map[string]SubscriberSettings)
+	}
+	sm.settingsByDevice[event.PubKey][device] = settings
+	log.Printf("⚙️ Settings for pubkey %s device %q: %+v", event.PubKey, device, settings)
+	return rejections
+}
+
+// Get returns the zero value for unknown devices, which means defaults.
+func (sm *SettingsManager) Get(pubkey, device string) SubscriberSettings {
+	return sm.settingsByDevice[pubkey][device]
+}
+
+func (sm *SettingsManager) Remove(pubkey, device string) {
+	delete(sm.settingsByDevice[pubkey], device)
+	if len(sm.settingsByDevice[pubkey]) == 0 {
+		delete(sm.settingsByDevice, pubkey)
+	}
+}
+
+// parseSettings fails only if the content can't be read at all. An invalid
+// setting is rejected on its own and left at its default.
+func parseSettings(event nostr.Event) (SubscriberSettings, []Rejection, error) {
+	var settings SubscriberSettings
+	if err := json.Unmarshal([]byte(event.Content), &settings); err != nil {
+		return settings, nil, err
+	}
+
+	var rejections []Rejection
+	if settings.Timezone != "" {
+		if _, err := time.LoadLocation(settings.Timezone); err != nil {
+			reject(&rejections, "timezone", "ignored: unknown timezone %q", settings.Timezone)
+			settings.Timezone = ""
+		}
+	}
+	if settings.QuietHours != nil {
+		if err := settings.QuietHours.validate(); err != nil {
+			reject(&rejections, "quietHours", "ignored: %v", err)
+			settings.QuietHours = nil
+		}
+	}
+	return settings, rejections, nil
+}
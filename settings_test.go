@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		want     SubscriberSettings
+		rejected []string
+		wantErr  bool
+	}{
+		{
+			name:    "all set",
+			content: `{"timezone":"Europe/Berlin","quietHours":{"start":"22:00","end":"07:00"},"locale":"de-DE"}`,
+			want: SubscriberSettings{
+				Timezone:   "Europe/Berlin",
+				QuietHours: &QuietHours{Start: "22:00", End: "07:00"},
+				Locale:     "de-DE",
+			},
+		},
+		{
+			name:     "unknown timezone alone",
+			content:  `{"timezone":"Mars/Olympus","locale":"de"}`,
+			want:     SubscriberSettings{Locale: "de"},
+			rejected: []string{"timezone"},
+		},
+		{
+			name:     "invalid quiet hours alone",
+			content:  `{"timezone":"UTC","quietHours":{"start":"25:00","end":"07:00"}}`,
+			want:     SubscriberSettings{Timezone: "UTC"},
+			rejected: []string{"quietHours"},
+		},
+		{
+			name:    "defaults",
+			content: `{"filters":[]}`,
+		},
+		{
+			name:    "invalid JSON",
+			content: `{`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rejections, err := parseSettings(nostr.Event{Kind: KindAppData, Content: tt.content})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSettings error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSettings = %+v, want %+v", got, tt.want)
+			}
+			var fields []string
+			for _, r := range rejections {
+				fields = append(fields, r.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.rejected) {
+				t.Errorf("rejected %v, want %v", fields, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestSettingsPerDevice(t *testing.T) {
+	device := func(d, content string) nostr.Event {
+		return nostr.Event{Kind: KindDeviceAppData, PubKey: "alice", Tags: nostr.Tags{{"d", d}}, Content: content}
+	}
+	sm := NewSettingsManager()
+	sm.UpdateSettings(nostr.Event{Kind: KindAppData, PubKey: "alice", Content: `{"locale":"en"}`})
+	sm.UpdateSettings(device("phone", `{"locale":"de","timezone":"Europe/Berlin"}`))
+	sm.UpdateSettings(device("tablet", `{"locale":"fr"}`))
+
+	tests := []struct {
+		pubkey, device string
+		locale         string
+		timezone       string
+	}{
+		{"alice", "", "en", ""},
+		{"alice", "phone", "de", "Europe/Berlin"},
+		{"alice", "tablet", "fr", ""},
+		{"alice", "laptop", "", ""},
+		{"bob", "", "", ""},
+	}
+	for _, tt := range tests {
+		got := sm.Get(tt.pubkey, tt.device)
+		if got.Locale != tt.locale || got.Timezone != tt.timezone {
+			t.Errorf("Get(%q, %q) = %+v, want locale %q timezone %q", tt.pubkey, tt.device, got, tt.locale, tt.timezone)
+		}
+	}
+
+	sm.Remove("alice", "phone")
+	if got := sm.Get("alice", "phone"); got.Locale != "" {
+		t.Errorf("removed device still has settings %+v", got)
+	}
+	if got := sm.Get("alice", "tablet"); got.Locale != "fr" {
+		t.Errorf("removing one device dropped another: %+v", got)
+	}
+}
+
+func TestCheckDeviceTag(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  nostr.Event
+		reject bool
+	}{
+		{"10395", nostr.Event{Kind: KindAppData}, false},
+		{"30395 with d", nostr.Event{Kind: KindDeviceAppData, Tags: nostr.Tags{{"d", "phone"}}}, false},
+		{"30395 empty d", nostr.Event{Kind: KindDeviceAppData, Tags: nostr.Tags{{"d", ""}}}, true},
+		{"30395 without d", nostr.Event{Kind: KindDeviceAppData}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkDeviceTag(tt.event); (got != nil) != tt.reject {
+				t.Errorf("checkDeviceTag = %v, want rejection %v", got, tt.reject)
+			}
+		})
+	}
+}
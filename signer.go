@@ -0,0 +1,123 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// Signer does everything the daemon needs its private key for. The key is
+// either held in memory, or by a NIP-46 bunker.
+type Signer interface {
+	Sign(event *nostr.Event) error
+	Encrypt(plaintext, recipientPublicKey string) (string, error)
+	Decrypt(ciphertext, senderPublicKey string) (string, error)
+}
+
+// sharedSecretCacheSize is how many subscribers' shared secrets, or with a
+// bunker decrypted subscriptions, are kept.
+var sharedSecretCacheSize = 1000
+
+// localSigner holds the private key itself. Computing a shared secret is the
+// expensive part of nip04, so they are cached for subscribers seen often.
+type localSigner struct {
+	privateKey string
+	secrets    *lruCache[[]byte]
+}
+
+func newLocalSigner(privateKey string) *localSigner {
+	return &localSigner{
+		privateKey: privateKey,
+		secrets:    newLRUCache[[]byte](sharedSecretCacheSize),
+	}
+}
+
+func (s *localSigner) Sign(event *nostr.Event) error {
+	return event.Sign(s.privateKey)
+}
+
+func (s *localSigner) sharedSecret(publicKey string) ([]byte, error) {
+	if shared, ok := s.secrets.Get(publicKey); ok {
+		return shared, nil
+	}
+	shared, err := nip04.ComputeSharedSecret(publicKey, s.privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute shared secret: %v", err)
+	}
+	s.secrets.Put(publicKey, shared)
+	return shared, nil
+}
+
+func (s *localSigner) Encrypt(plaintext, recipientPublicKey string) (string, error) {
+	shared, err := s.sharedSecret(recipientPublicKey)
+	if err != nil {
+		return "", err
+	}
+	return nip04.Encrypt(plaintext, shared)
+}
+
+func (s *localSigner) Decrypt(ciphertext, senderPublicKey string) (string, error) {
+	shared, err := s.sharedSecret(senderPublicKey)
+	if err != nil {
+		return "", err
+	}
+	return nip04.Decrypt(ciphertext, shared)
+}
+
+// lruCache keeps the size most recently used values. It is safe for
+// concurrent use.
+type lruCache[V any] struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *lruEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newLRUCache[V any](size int) *lruCache[V] {
+	return &lruCache[V]{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry[V]).value, true
+}
+
+func (c *lruCache[V]) Put(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size <= 0 {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*lruEntry[V]).value = value
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry[V]).key)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestLRUCache(t *testing.T) {
+	type op struct {
+		put   bool
+		key   string
+		value int
+	}
+	tests := []struct {
+		name    string
+		size    int
+		ops     []op
+		present map[string]int
+		absent  []string
+	}{
+		{
+			name:    "keeps up to size",
+			size:    2,
+			ops:     []op{{true, "a", 1}, {true, "b", 2}},
+			present: map[string]int{"a": 1, "b": 2},
+		},
+		{
+			name:    "evicts least recently put",
+			size:    2,
+			ops:     []op{{true, "a", 1}, {true, "b", 2}, {true, "c", 3}},
+			present: map[string]int{"b": 2, "c": 3},
+			absent:  []string{"a"},
+		},
+		{
+			name:    "get refreshes",
+			size:    2,
+			ops:     []op{{true, "a", 1}, {true, "b", 2}, {false, "a", 0}, {true, "c", 3}},
+			present: map[string]int{"a": 1, "c": 3},
+			absent:  []string{"b"},
+		},
+		{
+			name:    "put replaces and refreshes",
+			size:    2,
+			ops:     []op{{true, "a", 1}, {true, "b", 2}, {true, "a", 10}, {true, "c", 3}},
+			present: map[string]int{"a": 10, "c": 3},
+			absent:  []string{"b"},
+		},
+		{
+			name:   "size zero caches nothing",
+			size:   0,
+			ops:    []op{{true, "a", 1}},
+			absent: []string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newLRUCache[int](tt.size)
+			for _, o := range tt.ops {
+				if o.put {
+					c.Put(o.key, o.value)
+				} else {
+					c.Get(o.key)
+				}
+			}
+			for key, want := range tt.present {
+				if got, ok := c.Get(key); !ok || got != want {
+					t.Errorf("Get(%q) = %d, %v, want %d, true", key, got, ok, want)
+				}
+			}
+			for _, key := range tt.absent {
+				if _, ok := c.Get(key); ok {
+					t.Errorf("Get(%q) found an evicted entry", key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+// authorQuota caps how many subscribers a single author can get notified
+// within a time window, so one pubkey posting lots of notes to a popular
+// area can't fan out into thousands of pushes.
+type authorQuota struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[string]*quotaWindow
+}
+
+type quotaWindow struct {
+	start time.Time
+	used  int
+}
+
+var fanoutQuota = newAuthorQuota(0, time.Hour)
+
+func newAuthorQuota(limit int, window time.Duration) *authorQuota {
+	return &authorQuota{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*quotaWindow),
+	}
+}
+
+// Take reserves up to n notifications for author and returns how many are
+// left in its current window. A limit of zero disables the quota.
+func (q *authorQuota) Take(author string, n int) int {
+	if q.limit <= 0 {
+		return n
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	w, ok := q.windows[author]
+	if !ok || now.Sub(w.start) >= q.window {
+		w = &quotaWindow{start: now}
+		q.windows[author] = w
+		q.prune(now)
+	}
+
+	allowed := min(n, q.limit-w.used)
+	w.used += allowed
+	if allowed < n {
+		log.Printf("🛑 Author %s reached the fan-out quota of %d per %s, dropping %d notifications", author, q.limit, q.window, n-allowed)
+	}
+	return allowed
+}
+
+func (q *authorQuota) prune(now time.Time) {
+	for author, w := range q.windows {
+		if now.Sub(w.start) >= q.window {
+			delete(q.windows, author)
+		}
+	}
+}
+
+// hasPow checks the NIP-13 proof of work of an event id.
+func hasPow(eventID string, minDifficulty int) bool {
+	return minDifficulty <= 0 || nip13.Difficulty(eventID) >= minDifficulty
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// StartupConfig controls how stored subscriptions are loaded at boot.
+type StartupConfig struct {
+	Relays   []string
+	Timeout  time.Duration // per relay, for all pages together
+	PageSize int
+}
+
+func loadStartupConfig(strfryHost string) StartupConfig {
+	return StartupConfig{
+		Relays:   getEnvList("STARTUP_RELAYS", []string{strfryHost}),
+		Timeout:  getEnvDuration("STARTUP_RELAY_TIMEOUT", 60*time.Second),
+		PageSize: getEnvInt("STARTUP_PAGE_SIZE", 500),
+	}
+}
+
+// readStrfryEvents loads all stored subscription events addressed to us from
+// every configured relay and keeps only the latest one per pubkey and device,
+// oldest first so later settings win. A relay
+// that fails or runs into its timeout is logged and what it returned until
+// then is kept, so the boot is never blocked; only if nothing could be read
+// from any relay an error is returned.
+func readStrfryEvents(cfg StartupConfig) ([]nostr.Event, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		latest   = make(map[string]nostr.Event)
+		failures int
+	)
+
+	for _, url := range cfg.Relays {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+
+			events, err := readRelayEvents(url, cfg)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures++
+				log.Printf("❌ Startup loading from %s failed after %d events: %v", url, len(events), err)
+			}
+			for _, ev := range events {
+				key := subscriptionKey(ev)
+				if cur, ok := latest[key]; !ok || isNewerReplaceable(ev, cur) {
+					latest[key] = ev
+				}
+			}
+		}(url)
+	}
+	wg.Wait()
+
+	if failures == len(cfg.Relays) {
+		if len(latest) == 0 {
+			return nil, fmt.Errorf("could not read from any of %d relays", len(cfg.Relays))
+		}
+		log.Printf("⚠️ All %d startup relays failed, continuing with the %d subscriptions read until then", len(cfg.Relays), len(latest))
+	}
+
+	events := make([]nostr.Event, 0, len(latest))
+	for _, ev := range latest {
+		events = append(events, ev)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].CreatedAt < events[j].CreatedAt })
+	log.Printf("Finished reading stored events: %d subscriptions from %d relays", len(events), len(cfg.Relays)-failures)
+	return events, nil
+}
+
+// isNewerReplaceable applies the NIP-01 rule for replaceable events: the
+// newest wins, on equal timestamps the lowest id.
+func isNewerReplaceable(a, b nostr.Event) bool {
+	if a.CreatedAt != b.CreatedAt {
+		return a.CreatedAt > b.CreatedAt
+	}
+	return a.ID < b.ID
+}
+
+// readRelayEvents pages backwards through a relay with `until` windows.
+// Events it got before an error or timeout are returned along with it.
+func readRelayEvents(url string, cfg StartupConfig) ([]nostr.Event, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %v", err)
+	}
+	defer relay.Close()
+
+	seen := make(map[string]bool)
+	var events []nostr.Event
+	var until *nostr.Timestamp
+
+	for page := 1; ; page++ {
+		filter := nostr.Filter{
+			Kinds: []int{KindAppData, KindDeviceAppData},
+			Tags:  nostr.TagMap{"p": keys.publicKeys()},
+			Limit: cfg.PageSize,
+			Until: until,
+		}
+
+		res, err := relay.QuerySync(ctx, filter)
+		if err != nil {
+			return events, fmt.Errorf("failed to query page %d: %v", page, err)
+		}
+		// QuerySync hands back what it has when the context runs out
+		if ctx.Err() != nil {
+			return events, fmt.Errorf("timed out on page %d after %s", page, cfg.Timeout)
+		}
+
+		added := 0
+		oldest := nostr.Now()
+		for _, ev := range res {
+			if ev.CreatedAt < oldest {
+				oldest = ev.CreatedAt
+			}
+			if seen[ev.ID] {
+				continue
+			}
+			seen[ev.ID] = true
+			events = append(events, *ev)
+			added++
+		}
+		log.Printf("📦 %s: page %d brought %d new events, %d so far", url, page, added, len(events))
+
+		// relays may cap limit below PageSize, so a short page is no sign
+		// of the end. The next window includes `oldest` again so that
+		// events sharing that second are not lost; if a page held nothing
+		// new, step past it, and stop once the window can't move back.
+		if len(res) == 0 {
+			return events, nil
+		}
+		if added == 0 {
+			oldest--
+			if oldest < 0 || (until != nil && oldest >= *until) {
+				return events, nil
+			}
+		}
+		until = &oldest
+	}
+}
+
+// readMuteLists fetches the NIP-51 mute lists of the given subscribers,
+// the latest per pubkey, in batches of authors. Lists read before a relay
+// failed are kept.
+func readMuteLists(cfg StartupConfig, pubkeys []string) ([]nostr.Event, error) {
+	latest := make(map[string]nostr.Event)
+	failures := 0
+
+	for _, url := range cfg.Relays {
+		for start := 0; start < len(pubkeys); start += cfg.PageSize {
+			end := min(start+cfg.PageSize, len(pubkeys))
+			filter := nostr.Filter{
+				Kinds:   []int{KindMuteList},
+				Authors: pubkeys[start:end],
+			}
+
+			// a relay that times out still returns what it sent until then
+			events, err := queryRelay(url, nostr.Filters{filter}, cfg.Timeout)
+			for _, ev := range events {
+				if cur, ok := latest[ev.PubKey]; !ok || isNewerReplaceable(ev, cur) {
+					latest[ev.PubKey] = ev
+				}
+			}
+			if err != nil {
+				failures++
+				log.Printf("❌ Loading mute lists from %s failed: %v", url, err)
+				break
+			}
+		}
+	}
+
+	events := make([]nostr.Event, 0, len(latest))
+	for _, ev := range latest {
+		events = append(events, ev)
+	}
+	log.Printf("Finished reading mute lists: %d of %d subscribers have one", len(events), len(pubkeys))
+
+	// the lists we did get are returned with the error
+	if len(pubkeys) > 0 && failures == len(cfg.Relays) {
+		return events, fmt.Errorf("could not read from any of %d relays", len(cfg.Relays))
+	}
+	return events, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestReadRelayEventsPagesPastCappedLimit(t *testing.T) {
+	defer func(k *KeyMaterial) { keys = k }(keys)
+	setupKeys(nostr.GeneratePrivateKey(), nil)
+
+	// subscriptions from 25 subscribers, a few sharing a second
+	var stored []nostr.Event
+	for i := range 25 {
+		sk := nostr.GeneratePrivateKey()
+		ev := nostr.Event{
+			Kind:      KindAppData,
+			CreatedAt: nostr.Timestamp(1700000000 + i/3),
+			Tags:      nostr.Tags{{"p", keys.publicKey}},
+			Content:   fmt.Sprint(i),
+		}
+		ev.Sign(sk)
+		stored = append(stored, ev)
+	}
+
+	tests := []struct {
+		name     string
+		maxLimit int
+		pageSize int
+	}{
+		{"relay honours limit", 0, 10},
+		{"relay caps limit below page size", 4, 10},
+		{"relay caps limit at a second's worth", 3, 10},
+		{"page bigger than everything", 0, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := newFakeRelay(t, tt.maxLimit, stored...)
+			events, err := readRelayEvents(relay.URL, StartupConfig{Timeout: 5 * time.Second, PageSize: tt.pageSize})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != len(stored) {
+				t.Errorf("read %d events, want %d", len(events), len(stored))
+			}
+		})
+	}
+}
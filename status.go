@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindSubscriptionStatus is our reply to a 10395 or 30395. It is addressable
+// with the subscription's key as `d`, so relays only keep the latest status.
+// The kind is not standardized.
+const KindSubscriptionStatus = 30396
+
+const (
+	StatusAccepted = "accepted"
+	StatusPartial  = "partial"  // some parts were rejected
+	StatusRejected = "rejected" // nothing from this subscription is used
+)
+
+// statusFilter is a filters entry as in the 10395 content. nostr.Filter
+// can't be embedded, its MarshalJSON would hide the options.
+type statusFilter struct {
+	Filter nostr.Filter `json:"filter"`
+	FilterOptions
+}
+
+// SubscriptionStatus is the decrypted content of a KindSubscriptionStatus.
+type SubscriptionStatus struct {
+	Version      int            `json:"version"`
+	Subscription string         `json:"subscription"` // id of the 10395
+	Device       string         `json:"device,omitempty"`
+	Status       string         `json:"status"`
+	Filters      []statusFilter `json:"filters"`
+	Tokens       []Pushtoken    `json:"tokens"`
+	Unverified   []Pushtoken    `json:"unverified,omitempty"`
+	Rejected     []Rejection    `json:"rejected"`
+	Limits       statusLimits   `json:"limits"`
+
+	// RotatedTo is our current pubkey, when the subscription was encrypted
+	// to a previous one. Clients should re-encrypt to it.
+	RotatedTo string `json:"rotatedTo,omitempty"`
+}
+
+type statusLimits struct {
+	MaxFilters        int  `json:"maxFilters"`
+	MaxTokens         int  `json:"maxTokens"`
+	MaxDevices        int  `json:"maxDevices"`
+	AllowBroadFilters bool `json:"allowBroadFilters"`
+}
+
+func currentLimits() statusLimits {
+	return statusLimits{
+		MaxFilters:        subscriptionLimits.MaxFilters,
+		MaxTokens:         subscriptionLimits.MaxTokens,
+		MaxDevices:        subscriptionLimits.MaxDevices,
+		AllowBroadFilters: subscriptionLimits.AllowBroadFilters,
+	}
+}
+
+// statusPublisher sends status replies to relays from a single background
+// worker, so a slow relay or bunker doesn't hold up the ingest loop. Replies
+// wait in a bounded queue and are dropped when it is full.
+type statusPublisher struct {
+	relays  []string
+	timeout time.Duration
+	queue   chan statusReply
+
+	// conns are only used by the worker
+	conns map[string]*nostr.Relay
+}
+
+// statusReplies is nil when replies are turned off.
+var statusReplies *statusPublisher
+
+func newStatusPublisher(relays []string, timeout time.Duration, queueSize int) *statusPublisher {
+	sp := &statusPublisher{
+		relays:  relays,
+		timeout: timeout,
+		queue:   make(chan statusReply, queueSize),
+		conns:   make(map[string]*nostr.Relay),
+	}
+	go sp.run()
+	return sp
+}
+
+// statusReply is a status waiting to be encrypted and signed by the worker.
+type statusReply struct {
+	signer    *KeyMaterial
+	status    SubscriptionStatus
+	recipient string
+	key       string
+}
+
+func (sp *statusPublisher) run() {
+	for r := range sp.queue {
+		event, err := statusEvent(r.signer, r.status, r.recipient, r.key)
+		if err != nil {
+			log.Printf("❌ Failed to create status reply for pubkey %s: %v", r.recipient, err)
+			continue
+		}
+		sp.publish(event)
+	}
+}
+
+// wholeSubscription are the fields whose rejection drops the subscription.
+var wholeSubscription = []string{"content", "version", "d", "expiration"}
+
+// subscriptionStatus describes a single subscription, for a device that is
+// only its own filters and tokens.
+func subscriptionStatus(reg *Registry, event nostr.Event) SubscriptionStatus {
+	device := deviceOf(event)
+	// tokens and rejections are copied, the worker encodes the status while
+	// the registry goes on
+	status := SubscriptionStatus{
+		Version:      subscriptionVersion,
+		Subscription: event.ID,
+		Device:       device,
+		Filters:      []statusFilter{},
+		Tokens:       slices.Clone(reg.pm.pushkeysByDevice[event.PubKey][device]),
+		Rejected:     slices.Clone(reg.rejections[subscriptionKey(event)]),
+		Limits:       currentLimits(),
+	}
+	if key := addressedKey(event); key != nil && key != keys {
+		status.RotatedTo = keys.publicKey
+	}
+	for _, f := range reg.fm.filtersByDevice[event.PubKey][device] {
+		status.Filters = append(status.Filters, statusFilter{f.Filter, f.FilterOptions})
+	}
+	if tokenVerification != nil {
+		active := tokenVerification.Active(event.PubKey, status.Tokens)
+		for _, token := range status.Tokens {
+			if !slices.Contains(active, token) {
+				status.Unverified = append(status.Unverified, token)
+			}
+		}
+	}
+	if status.Tokens == nil {
+		status.Tokens = []Pushtoken{}
+	}
+	if status.Rejected == nil {
+		status.Rejected = []Rejection{}
+	}
+
+	switch {
+	case len(status.Rejected) == 0:
+		status.Status = StatusAccepted
+	case len(status.Filters) == 0 || slices.ContainsFunc(status.Rejected, func(r Rejection) bool {
+		return slices.Contains(wholeSubscription, r.Field)
+	}):
+		status.Status = StatusRejected
+	default:
+		status.Status = StatusPartial
+	}
+	return status
+}
+
+// Reply builds the status for a processed 10395 and queues it. It reads
+// the registry, so it has to be called from the ingest goroutine. Encrypting
+// and signing, possibly with a remote bunker, is left to the worker.
+func (sp *statusPublisher) Reply(reg *Registry, event nostr.Event) {
+	status := subscriptionStatus(reg, event)
+	// answer with the key the client knows, the reply tells it about ours
+	signer := addressedKey(event)
+	if signer == nil {
+		signer = keys
+	}
+	reply := statusReply{signer: signer, status: status, recipient: event.PubKey, key: subscriptionKey(event)}
+	select {
+	case sp.queue <- reply:
+		log.Printf("📨 Replying %s to subscription %s from pubkey %s", status.Status, event.ID, event.PubKey)
+	default:
+		log.Printf("⚠️ Status queue is full, dropping reply to subscription %s", event.ID)
+	}
+}
+
+func statusEvent(signer *KeyMaterial, status SubscriptionStatus, recipient, key string) (nostr.Event, error) {
+	content, err := json.Marshal(status)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	encrypted, err := encryptContent(string(content), recipient, signer)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
+	return signedEvent(signer, KindSubscriptionStatus, nostr.Tags{
+		{"d", key},
+		{"p", recipient},
+		{"e", status.Subscription},
+	}, encrypted)
+}
+
+func (sp *statusPublisher) publish(event nostr.Event) {
+	for _, url := range sp.relays {
+		if err := sp.publishTo(url, event); err != nil {
+			log.Printf("❌ Failed to publish status %s to %s: %v", event.ID, url, err)
+		}
+	}
+}
+
+// publishTo reuses the connection to url, and reconnects once it dropped or
+// failed to publish.
+func (sp *statusPublisher) publishTo(url string, event nostr.Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sp.timeout)
+	defer cancel()
+
+	relay := sp.conns[url]
+	if relay == nil || !relay.IsConnected() {
+		var err error
+		if relay, err = nostr.RelayConnect(ctx, url); err != nil {
+			return err
+		}
+		sp.conns[url] = relay
+	}
+	if err := relay.Publish(ctx, event); err != nil {
+		relay.Close()
+		delete(sp.conns, url)
+		return err
+	}
+	return nil
+}
+
+func publishToRelay(url string, event nostr.Event, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+
+	return relay.Publish(ctx, event)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSubscriptionStatus(t *testing.T) {
+	event := nostr.Event{ID: "sub1", PubKey: "alice", Kind: KindAppData}
+	filters := []SubscriptionFilter{{Filter: nostr.Filter{Kinds: []int{1}}}}
+	tests := []struct {
+		name       string
+		filters    []SubscriptionFilter
+		rejections []Rejection
+		want       string
+	}{
+		{"accepted", filters, nil, StatusAccepted},
+		{"partial", filters, []Rejection{{Field: "filters[1].filter", Reason: "bad"}}, StatusPartial},
+		{"no filters left", nil, []Rejection{{Field: "filters[0].filter", Reason: "bad"}}, StatusRejected},
+		{"invalid JSON", filters, []Rejection{{Field: "content", Reason: "invalid JSON"}}, StatusRejected},
+		{"not decryptable", filters, []Rejection{{Field: "content", Reason: "could not decrypt"}}, StatusRejected},
+		{"version", filters, []Rejection{{Field: "version", Reason: "unsupported"}}, StatusRejected},
+		{"device limit", filters, []Rejection{{Field: "d", Reason: "too many"}}, StatusRejected},
+		{"whole after partial", filters, []Rejection{{Field: "timezone", Reason: "bad"}, {Field: "expiration", Reason: "expired"}}, StatusRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := NewRegistry()
+			if tt.filters != nil {
+				reg.fm.filtersByDevice["alice"] = FilterMap{"": tt.filters}
+			}
+			reg.rejections["alice"] = tt.rejections
+			if got := subscriptionStatus(reg, event).Status; got != tt.want {
+				t.Errorf("status = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// dataDir is where the daemon keeps state that has to survive restarts.
+var dataDir = "data"
+
+func setupDataDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create data dir %s: %v", dir, err)
+	}
+	dataDir = dir
+	return nil
+}
+
+// loadJSON reads dataDir/name into v. A missing file is not an error, v is
+// left untouched in that case and found is false.
+func loadJSON(name string, v any) (found bool, err error) {
+	b, err := os.ReadFile(filepath.Join(dataDir, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return false, fmt.Errorf("failed to parse %s: %v", name, err)
+	}
+	return true, nil
+}
+
+// saveJSON writes v to dataDir/name. It writes to a temporary file first so
+// a crash never leaves a half written file behind.
+func saveJSON(name string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dataDir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// saveState persists everything that is only kept in memory while running.
+func saveState() {
+	checkpoint.Save()
+	seenEvents.Save()
+	digests.Save()
+	if tokenVerification != nil {
+		tokenVerification.Save()
+	}
+}
+
+// saveStateEvery writes the state in the background; writing it for every
+// single event would be a waste.
+func saveStateEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		saveState()
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// pluginResponse is what strfry expects back from a write policy plugin.
+type pluginResponse struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	Msg    string `json:"msg"`
+}
+
+// parseStreamLine accepts both the strfry wrapper (`event`, `type`,
+// `receivedAt`, `sourceInfo`) and a bare event as printed by `strfry stream`.
+func parseStreamLine(line []byte) (strfryMessage, error) {
+	var wrapper strfryMessage
+	var probe struct {
+		Event json.RawMessage `json:"event"`
+	}
+	if err := json.Unmarshal(line, &probe); err != nil {
+		return wrapper, err
+	}
+
+	if probe.Event != nil {
+		err := json.Unmarshal(line, &wrapper)
+		return wrapper, err
+	}
+
+	wrapper.Type = "new"
+	wrapper.SourceInfo = "stream"
+	err := json.Unmarshal(line, &wrapper.Event)
+	return wrapper, err
+}
+
+// pluginRejects has the plugin reject subscriptions to us that we would
+// refuse anyway, so relays don't store them. Otherwise everything is accepted.
+var pluginRejects bool
+
+// rejectReason is why a subscription event is refused, "" to accept it.
+func rejectReason(event nostr.Event) string {
+	if !isAppData(event.Kind) || addressedKey(event) == nil {
+		return ""
+	}
+	if !strings.Contains(event.Content, "?iv=") {
+		return "subscriptions must be nip04 encrypted"
+	}
+	if event.Kind == KindDeviceAppData && event.Tags.GetD() == "" {
+		return "a 30395 needs a d tag"
+	}
+	if r := checkExpiration(event); r != nil {
+		return r.Reason
+	}
+	return ""
+}
+
+// recoverID digs the event id out of a line that didn't parse as a whole.
+func recoverID(line []byte) string {
+	var probe struct {
+		ID    string `json:"id"`
+		Event struct {
+			ID string `json:"id"`
+		} `json:"event"`
+	}
+	// type errors still fill in the fields that fit
+	json.Unmarshal(line, &probe)
+	if probe.Event.ID != "" {
+		return probe.Event.ID
+	}
+	return probe.ID
+}
+
+// pluginAnswer is the response to a line, false if there is no id to answer.
+func pluginAnswer(wrapper strfryMessage, parseErr error, line []byte) (pluginResponse, bool) {
+	if parseErr != nil {
+		id := recoverID(line)
+		if id == "" {
+			return pluginResponse{}, false
+		}
+		if pluginRejects {
+			return pluginResponse{ID: id, Action: "reject", Msg: "invalid: malformed event"}, true
+		}
+		return pluginResponse{ID: id, Action: "accept"}, true
+	}
+
+	resp := pluginResponse{ID: wrapper.Event.ID, Action: "accept"}
+	if pluginRejects {
+		if reason := rejectReason(wrapper.Event); reason != "" {
+			resp.Action = "reject"
+			resp.Msg = "blocked: " + reason
+		}
+	}
+	return resp, true
+}
+
+// readStream processes JSON lines from r. With respond set, every line is
+// answered on w before it is processed, so strfry doesn't wait for us.
+// process should only queue the event, see streamWorker.
+func readStream(r io.Reader, w io.Writer, respond bool, process func(strfryMessage)) error {
+	reader := bufio.NewReader(r)
+	enc := json.NewEncoder(w)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			wrapper, perr := parseStreamLine(line)
+
+			if respond {
+				if resp, ok := pluginAnswer(wrapper, perr, line); ok {
+					if werr := enc.Encode(resp); werr != nil {
+						return fmt.Errorf("failed to write plugin response: %v", werr)
+					}
+				} else {
+					log.Printf("⛔ No event id in unparsable line, not answering it")
+				}
+			}
+
+			if perr != nil {
+				log.Printf("❌ Failed to parse stream line: %v", perr)
+			} else if wrapper.Type == "" || wrapper.Type == "new" || isAppData(wrapper.Event.Kind) {
+				// lookback events were already seen by strfry before we started
+				process(wrapper)
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read stream: %v", err)
+		}
+	}
+}
+
+// streamWorker processes queued events one at a time, so reading and
+// answering lines never waits for push delivery.
+type streamWorker struct {
+	queue chan strfryMessage
+	done  chan struct{}
+}
+
+func startStreamWorker(reg *Registry, size int) *streamWorker {
+	w := &streamWorker{
+		queue: make(chan strfryMessage, size),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		for wrapper := range w.queue {
+			processEvent(reg, wrapper.Event, wrapper.SourceInfo)
+		}
+	}()
+	return w
+}
+
+// enqueue blocks once the queue is full, slowing the producer down rather
+// than dropping events.
+func (w *streamWorker) enqueue(wrapper strfryMessage) {
+	w.queue <- wrapper
+}
+
+// stop processes what is queued and returns.
+func (w *streamWorker) stop() {
+	close(w.queue)
+	<-w.done
+}
+
+func readStdin(respond bool, queueSize int, reg *Registry) error {
+	log.Printf("Starting to consume events from stdin with %d filters", len(reg.fm.GetAllFilters()))
+
+	worker := startStreamWorker(reg, queueSize)
+	defer worker.stop()
+	return readStream(os.Stdin, os.Stdout, respond, worker.enqueue)
+}
+
+// readUnixSocket serves any number of stream producers on a unix socket.
+// Connections are read concurrently, events are processed one at a time by
+// the worker.
+func readUnixSocket(path string, respond bool, queueSize int, reg *Registry) error {
+	// a stale socket from a previous run would make Listen fail
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove old socket: %v", err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", path, err)
+	}
+	defer listener.Close()
+
+	log.Printf("Starting to consume events from unix socket %s with %d filters", path, len(reg.fm.GetAllFilters()))
+
+	worker := startStreamWorker(reg, queueSize)
+	defer worker.stop()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return fmt.Errorf("failed to accept connection: %v", err)
+		}
+
+		go func() {
+			defer conn.Close()
+			log.Printf("🔌 Stream producer connected on %s", path)
+			if err := readStream(conn, conn, respond, worker.enqueue); err != nil {
+				log.Printf("❌ %v", err)
+			}
+			log.Printf("🔌 Stream producer disconnected from %s", path)
+		}()
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRecoverID(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"wrapper with bad event", `{"event": {"id": "abc", "kind": "one"}, "type": "new"}`, "abc"},
+		{"bare event with bad kind", `{"id": "abc", "kind": "one"}`, "abc"},
+		{"not json", `{"id": "abc"`, ""},
+		{"no id", `{"event": {"kind": "one"}}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := recoverID([]byte(tt.line)); got != tt.want {
+				t.Errorf("recoverID = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadStreamAnswersBeforeProcessing(t *testing.T) {
+	in := strings.Join([]string{
+		`{"event": {"id": "a1", "kind": 1, "content": "hi", "tags": [], "created_at": 1, "pubkey": "p", "sig": "s"}, "type": "new"}`,
+		`{"event": {"id": "a2", "kind": "bad"}, "type": "new"}`,
+		`garbage`,
+	}, "\n") + "\n"
+
+	var out bytes.Buffer
+	var processed []string
+	err := readStream(strings.NewReader(in), &out, true, func(wrapper strfryMessage) {
+		if !strings.Contains(out.String(), wrapper.Event.ID) {
+			t.Errorf("%s processed before it was answered", wrapper.Event.ID)
+		}
+		processed = append(processed, wrapper.Event.ID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d answers, want 2: %q", len(lines), lines)
+	}
+	if !strings.Contains(lines[1], `"id":"a2"`) || !strings.Contains(lines[1], `"action":"accept"`) {
+		t.Errorf("unparsable event answered with %s", lines[1])
+	}
+	if len(processed) != 1 || processed[0] != "a1" {
+		t.Errorf("processed %v, want [a1]", processed)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+
+	"github.com/9ssi7/exponent"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// wantsTestPush reports an explicit `"test": true` in the 10395 content.
+func wantsTestPush(event nostr.Event) bool {
+	var content struct {
+		Test bool `json:"test"`
+	}
+	if err := json.Unmarshal([]byte(event.Content), &content); err != nil {
+		return false
+	}
+	return content.Test
+}
+
+func testMessage(reason string) exponent.Message {
+	return exponent.Message{
+		Title:    "Notifications are working",
+		Body:     "This device will be notified about your subscriptions.",
+		Priority: exponent.DefaultPriority,
+		Data: exponent.Data{
+			"type":   "test",
+			"reason": reason,
+		},
+	}
+}
+
+// sendTestPushes confirms new tokens of a device, or all tokens of the
+// subscriber when they asked for a test. before are the device's tokens known
+// until now.
+func sendTestPushes(reg *Registry, event nostr.Event, before []Pushtoken) {
+	tokens := reg.pm.pushkeysByPubkey[event.PubKey]
+	reason := "requested"
+	if !wantsTestPush(event) {
+		reason = "new token"
+		var added []Pushtoken
+		for _, token := range reg.pm.pushkeysByDevice[event.PubKey][deviceOf(event)] {
+			if !slices.Contains(before, token) {
+				added = append(added, token)
+			}
+		}
+		tokens = added
+	}
+	// unverified tokens are confirmed once they are verified
+	tokens = activeTokens(event.PubKey, tokens)
+	if len(tokens) == 0 {
+		return
+	}
+
+	sendTestPush(event.PubKey, tokens, reason)
+}
+
+// sendConfirmations tells freshly verified tokens that they are set up.
+func sendConfirmations(event nostr.Event, verified []Pushtoken) {
+	if len(verified) > 0 {
+		sendTestPush(event.PubKey, verified, "verified")
+	}
+}
+
+func sendTestPush(pubkey string, tokens []Pushtoken, reason string) {
+	sent, failed := sendPushCounted(tokens, testMessage(reason), testPushesSent, testPushesFailed)
+	if failed > 0 {
+		log.Printf("🧪❌ Test push (%s) for pubkey %s reached %d of %d tokens", reason, pubkey, sent, len(tokens))
+	} else {
+		log.Printf("🧪✅ Test push (%s) for pubkey %s sent to %d tokens", reason, pubkey, len(tokens))
+	}
+}
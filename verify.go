@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/9ssi7/exponent"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const tokenVerificationFile = "token-verification.json"
+
+// tokenClaim is one pubkey's claim on one token.
+type tokenClaim struct {
+	Nonce    string `json:"nonce,omitempty"`
+	SentAt   int64  `json:"sentAt,omitempty"`
+	Verified bool   `json:"verified,omitempty"`
+}
+
+// tokenVerifier keeps anyone from registering somebody else's token: a new
+// token gets a nonce pushed, and only once the subscriber echoes it back in
+// their signed subscription is the token used for notifications.
+type tokenVerifier struct {
+	mu     sync.Mutex
+	ttl    time.Duration // how long a nonce is valid, and how often it is resent
+	claims map[string]*tokenClaim
+	dirty  bool
+
+	// interval is the least time between two challenges to one token, no
+	// matter how many pubkeys claim it. challengedAt is not persisted.
+	interval     time.Duration
+	challengedAt map[Pushtoken]time.Time
+}
+
+// tokenVerification is nil when verification is off.
+var tokenVerification *tokenVerifier
+
+func claimKey(pubkey string, token Pushtoken) string {
+	return pubkey + " " + string(token)
+}
+
+// loadTokenVerifier restores the claims persisted in dataDir.
+func loadTokenVerifier(ttl, interval time.Duration) *tokenVerifier {
+	v := newTokenVerifier(ttl, interval)
+	if _, err := loadJSON(tokenVerificationFile, &v.claims); err != nil {
+		log.Printf("❌ Failed to load token verification, starting empty: %v", err)
+		v.claims = make(map[string]*tokenClaim)
+	}
+	log.Printf("🔐 Token verification on, %d claims known", len(v.claims))
+	return v
+}
+
+func newTokenVerifier(ttl, interval time.Duration) *tokenVerifier {
+	return &tokenVerifier{
+		ttl:          ttl,
+		claims:       make(map[string]*tokenClaim),
+		interval:     interval,
+		challengedAt: make(map[Pushtoken]time.Time),
+	}
+}
+
+// Verified reports whether pubkey has verified token.
+func (v *tokenVerifier) Verified(pubkey string, token Pushtoken) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	claim := v.claims[claimKey(pubkey, token)]
+	return claim != nil && claim.Verified
+}
+
+// Active drops the tokens pubkey hasn't verified yet.
+func (v *tokenVerifier) Active(pubkey string, tokens []Pushtoken) []Pushtoken {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var active []Pushtoken
+	for _, token := range tokens {
+		if claim := v.claims[claimKey(pubkey, token)]; claim != nil && claim.Verified {
+			active = append(active, token)
+		}
+	}
+	return active
+}
+
+// Challenge returns a fresh nonce for every token that is neither verified
+// nor waiting for a nonce sent within the ttl, and wasn't challenged for any
+// pubkey within the interval.
+func (v *tokenVerifier) Challenge(pubkey string, tokens []Pushtoken) map[Pushtoken]string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	nonces := make(map[Pushtoken]string)
+	for _, token := range tokens {
+		claim := v.claims[claimKey(pubkey, token)]
+		if claim != nil && (claim.Verified || now.Sub(time.Unix(claim.SentAt, 0)) < v.ttl) {
+			continue
+		}
+		if last, ok := v.challengedAt[token]; ok && now.Sub(last) < v.interval {
+			log.Printf("🔐 Token %s was challenged %s ago, not challenging it for %s", token, now.Sub(last).Round(time.Second), pubkey)
+			continue
+		}
+		nonce, err := newNonce()
+		if err != nil {
+			log.Printf("❌ Failed to create nonce: %v", err)
+			continue
+		}
+		v.claims[claimKey(pubkey, token)] = &tokenClaim{Nonce: nonce, SentAt: now.Unix()}
+		v.challengedAt[token] = now
+		v.dirty = true
+		nonces[token] = nonce
+	}
+	return nonces
+}
+
+// Confirm verifies token for pubkey if nonce is the one we sent.
+func (v *tokenVerifier) Confirm(pubkey string, token Pushtoken, nonce string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	claim := v.claims[claimKey(pubkey, token)]
+	switch {
+	case claim == nil:
+		return fmt.Errorf("no verification pending for this token")
+	case claim.Verified:
+		return nil
+	case claim.Nonce != nonce:
+		return fmt.Errorf("wrong nonce")
+	case time.Since(time.Unix(claim.SentAt, 0)) > v.ttl:
+		return fmt.Errorf("nonce expired")
+	}
+	claim.Verified = true
+	claim.Nonce = ""
+	v.dirty = true
+	return nil
+}
+
+// Retain forgets the claims of pubkey on tokens it no longer has.
+func (v *tokenVerifier) Retain(pubkey string, tokens []Pushtoken) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	keep := make(map[string]bool)
+	for _, token := range tokens {
+		keep[claimKey(pubkey, token)] = true
+	}
+	for key := range v.claims {
+		if strings.HasPrefix(key, pubkey+" ") && !keep[key] {
+			delete(v.claims, key)
+			v.dirty = true
+		}
+	}
+}
+
+func (v *tokenVerifier) Save() {
+	v.mu.Lock()
+	for token, last := range v.challengedAt {
+		if time.Since(last) >= v.interval {
+			delete(v.challengedAt, token)
+		}
+	}
+	if !v.dirty {
+		v.mu.Unlock()
+		return
+	}
+	// claims are changed in place once verified, so they are copied
+	claims := make(map[string]tokenClaim, len(v.claims))
+	for key, claim := range v.claims {
+		claims[key] = *claim
+	}
+	v.dirty = false
+	v.mu.Unlock()
+
+	if err := saveJSON(tokenVerificationFile, claims); err != nil {
+		log.Printf("❌ Failed to save token verification: %v", err)
+		v.mu.Lock()
+		v.dirty = true
+		v.mu.Unlock()
+	}
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// activeTokens is tokens as they may be notified, all of them when
+// verification is off.
+func activeTokens(pubkey string, tokens []Pushtoken) []Pushtoken {
+	if tokenVerification == nil {
+		return tokens
+	}
+	return tokenVerification.Active(pubkey, tokens)
+}
+
+// applyVerifications checks the nonces echoed in a subscription, as
+//
+//	"verify": [{ "expoPushToken": "ExponentPushToken[...]", "nonce": "<hex>" }]
+//
+// and returns the tokens verified by it.
+func applyVerifications(event nostr.Event) ([]Pushtoken, []Rejection) {
+	var content struct {
+		Verify []struct {
+			ExpoPushToken string `json:"expoPushToken"`
+			Nonce         string `json:"nonce"`
+		} `json:"verify"`
+	}
+	if err := json.Unmarshal([]byte(event.Content), &content); err != nil {
+		return nil, nil
+	}
+
+	var verified []Pushtoken
+	var rejections []Rejection
+	for i, echo := range content.Verify {
+		token := Pushtoken(echo.ExpoPushToken)
+		if err := tokenVerification.Confirm(event.PubKey, token, echo.Nonce); err != nil {
+			reject(&rejections, fmt.Sprintf("verify[%d]", i), "%v", err)
+			continue
+		}
+		log.Printf("🔐 Pubkey %s verified token %s", event.PubKey, token)
+		verified = append(verified, token)
+	}
+	return verified, rejections
+}
+
+// dropContested rejects tokens that already belong to too many other pubkeys,
+// which hints at someone spreading a token they don't own. With verification
+// on only verified claims count, so unverified ones can't lock the owner out.
+func (pm *PushManager) dropContested(pubkey string, tokens []Pushtoken, rejections *[]Rejection) []Pushtoken {
+	limit := subscriptionLimits.MaxPubkeysPerToken
+	if limit <= 0 {
+		return tokens
+	}
+	var res []Pushtoken
+	for _, token := range tokens {
+		others := 0
+		for other := range pm.pubkeysByToken[token] {
+			if other != pubkey && (tokenVerification == nil || tokenVerification.Verified(other, token)) {
+				others++
+			}
+		}
+		if others >= limit {
+			log.Printf("🚨 Token %s is claimed by %d pubkeys, refusing it for %s", token, others+1, pubkey)
+			tokenClaimConflicts.Add(1)
+			reject(rejections, "tokens", "token %s is already used by %d other pubkeys", token, others)
+			continue
+		}
+		res = append(res, token)
+	}
+	return res
+}
+
+// evictUnverified drops token from the other pubkeys that registered it
+// without verifying, once one pubkey has verified it.
+func (reg *Registry) evictUnverified(token Pushtoken, owner string) {
+	for pubkey := range reg.pm.pubkeysByToken[token] {
+		if pubkey == owner || tokenVerification.Verified(pubkey, token) {
+			continue
+		}
+		log.Printf("🔐 Token %s was verified by %s, dropping the unverified claim of %s", token, owner, pubkey)
+		for device, tokens := range reg.pm.pushkeysByDevice[pubkey] {
+			reg.pm.pushkeysByDevice[pubkey][device] = slices.DeleteFunc(slices.Clone(tokens), func(t Pushtoken) bool {
+				return t == token
+			})
+		}
+		reg.pm.merge(pubkey)
+		tokenVerification.Retain(pubkey, reg.pm.pushkeysByPubkey[pubkey])
+	}
+}
+
+// verificationMessage is silent, the app reads the nonce from the data.
+func verificationMessage(nonce string) exponent.Message {
+	return exponent.Message{
+		Priority: exponent.NormalPriority,
+		Data: exponent.Data{
+			"type":  "verify",
+			"nonce": nonce,
+		},
+	}
+}
+
+// sendVerificationPushes sends a nonce to each unverified token of the
+// subscription's device.
+func sendVerificationPushes(reg *Registry, event nostr.Event) {
+	challenge(event.PubKey, reg.pm.pushkeysByDevice[event.PubKey][deviceOf(event)])
+}
+
+// challenge pushes nonces to the tokens that need one. Tokens registered
+// before verification was turned on get theirs with their next match.
+func challenge(pubkey string, tokens []Pushtoken) {
+	for token, nonce := range tokenVerification.Challenge(pubkey, tokens) {
+		log.Printf("🔐 Sending verification nonce to %s for pubkey %s", token, pubkey)
+		sendPush([]Pushtoken{token}, verificationMessage(nonce))
+	}
+}
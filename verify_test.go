@@ -0,0 +1,134 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+const contestedToken = Pushtoken("ExponentPushToken[contested]")
+
+// verifiedClaims returns a verifier where the given pubkeys verified token.
+func verifiedClaims(token Pushtoken, pubkeys ...string) *tokenVerifier {
+	v := newTokenVerifier(time.Hour, time.Minute)
+	for _, pubkey := range pubkeys {
+		v.claims[claimKey(pubkey, token)] = &tokenClaim{Verified: true}
+	}
+	return v
+}
+
+func TestDropContested(t *testing.T) {
+	defer func(l SubscriptionLimits, v *tokenVerifier) {
+		subscriptionLimits, tokenVerification = l, v
+	}(subscriptionLimits, tokenVerification)
+	subscriptionLimits.MaxPubkeysPerToken = 2
+
+	tests := []struct {
+		name      string
+		claimants []string
+		verifier  *tokenVerifier
+		pubkey    string
+		kept      bool
+	}{
+		{"free token", nil, nil, "alice", true},
+		{"below limit", []string{"bob"}, nil, "alice", true},
+		{"at limit without verification", []string{"bob", "carol"}, nil, "alice", false},
+		{"own claim not counted", []string{"alice", "bob"}, nil, "alice", true},
+		{"unverified claims ignored", []string{"bob", "carol"}, verifiedClaims(contestedToken), "alice", true},
+		{"one verified claim", []string{"bob", "carol"}, verifiedClaims(contestedToken, "bob"), "alice", true},
+		{"verified claims at limit", []string{"bob", "carol"}, verifiedClaims(contestedToken, "bob", "carol"), "alice", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenVerification = tt.verifier
+			pm := NewPushManager()
+			for _, pubkey := range tt.claimants {
+				if pm.pubkeysByToken[contestedToken] == nil {
+					pm.pubkeysByToken[contestedToken] = make(map[string]bool)
+				}
+				pm.pubkeysByToken[contestedToken][pubkey] = true
+			}
+
+			var rejections []Rejection
+			got := pm.dropContested(tt.pubkey, []Pushtoken{contestedToken}, &rejections)
+			if kept := slices.Contains(got, contestedToken); kept != tt.kept {
+				t.Errorf("kept = %v, want %v (rejections %v)", kept, tt.kept, rejections)
+			}
+			if tt.kept == (len(rejections) > 0) {
+				t.Errorf("rejections = %v, want rejection %v", rejections, !tt.kept)
+			}
+		})
+	}
+}
+
+func TestEvictUnverified(t *testing.T) {
+	defer func(v *tokenVerifier) { tokenVerification = v }(tokenVerification)
+	tokenVerification = verifiedClaims(contestedToken, "alice", "carol")
+
+	other := Pushtoken("ExponentPushToken[other]")
+	reg := NewRegistry()
+	reg.pm.pushkeysByDevice["alice"] = map[string][]Pushtoken{"": {contestedToken}}
+	reg.pm.pushkeysByDevice["bob"] = map[string][]Pushtoken{"phone": {contestedToken, other}}
+	reg.pm.pushkeysByDevice["carol"] = map[string][]Pushtoken{"": {contestedToken}}
+	for _, pubkey := range []string{"alice", "bob", "carol"} {
+		reg.pm.merge(pubkey)
+	}
+
+	reg.evictUnverified(contestedToken, "alice")
+
+	tests := []struct {
+		pubkey string
+		want   []Pushtoken
+	}{
+		{"alice", []Pushtoken{contestedToken}},
+		{"bob", []Pushtoken{other}},
+		{"carol", []Pushtoken{contestedToken}},
+	}
+	for _, tt := range tests {
+		if got := reg.pm.pushkeysByPubkey[tt.pubkey]; !slices.Equal(got, tt.want) {
+			t.Errorf("tokens of %s = %v, want %v", tt.pubkey, got, tt.want)
+		}
+	}
+	if reg.pm.pubkeysByToken[contestedToken]["bob"] {
+		t.Errorf("bob still claims the token")
+	}
+}
+
+func TestChallengeInterval(t *testing.T) {
+	v := newTokenVerifier(time.Hour, time.Minute)
+
+	tests := []struct {
+		name   string
+		pubkey string
+		setup  func()
+		want   bool
+	}{
+		{"first challenge", "alice", nil, true},
+		{"same pubkey within ttl", "alice", nil, false},
+		{"other pubkey within interval", "bob", nil, false},
+		{"other pubkey after interval", "bob", func() {
+			v.challengedAt[contestedToken] = time.Now().Add(-2 * time.Minute)
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup()
+			}
+			_, got := v.Challenge(tt.pubkey, []Pushtoken{contestedToken})[contestedToken]
+			if got != tt.want {
+				t.Errorf("challenged = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerificationMessageIsSilent(t *testing.T) {
+	msg := verificationMessage("nonce")
+	if msg.Title != "" || msg.Body != "" {
+		t.Errorf("verification push has title %q and body %q", msg.Title, msg.Body)
+	}
+	if msg.Data["nonce"] != "nonce" {
+		t.Errorf("nonce missing from data: %v", msg.Data)
+	}
+}